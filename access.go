@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DENY_REGEXP_PREFIX marks deny rules written as regular expressions instead of glob patterns
+const DENY_REGEXP_PREFIX string = "regexp:"
+
+// accessRules Rules hiding files from clients, evaluated on request paths before opening any file
+type accessRules struct {
+	allowDotfiles     bool
+	dotfilesAllowlist map[string]bool
+	// globs are matched against each segment of the path
+	globs []string
+	// regexps are matched against the whole path
+	regexps []*regexp.Regexp
+}
+
+func newAccessRules(access Access) (*accessRules, error) {
+	rules := &accessRules{allowDotfiles: access.AllowDotfiles, dotfilesAllowlist: make(map[string]bool)}
+	for _, segment := range access.DotfilesAllowlist {
+		rules.dotfilesAllowlist[segment] = true
+	}
+
+	for _, rule := range access.Deny {
+		if strings.HasPrefix(rule, DENY_REGEXP_PREFIX) {
+			re, err := regexp.Compile(strings.TrimPrefix(rule, DENY_REGEXP_PREFIX))
+			if err != nil {
+				return nil, err
+			}
+			rules.regexps = append(rules.regexps, re)
+			continue
+		}
+		// Validates the pattern once, so that matching errors can be ignored later on
+		if _, err := path.Match(rule, ""); err != nil {
+			return nil, err
+		}
+		rules.globs = append(rules.globs, rule)
+	}
+	return rules, nil
+}
+
+// denied Reports whether the (decoded) request path must not be served, along with the rule that denied it
+func (a *accessRules) denied(urlPath string) (bool, string) {
+	urlPath = path.Clean("/" + urlPath)
+
+	for _, segment := range strings.Split(urlPath, "/") {
+		if segment == "" {
+			continue
+		}
+		if !a.allowDotfiles && segment[0] == '.' && !a.dotfilesAllowlist[segment] {
+			return true, "dotfile"
+		}
+		for _, glob := range a.globs {
+			if matched, _ := path.Match(glob, segment); matched {
+				return true, glob
+			}
+		}
+	}
+	for _, re := range a.regexps {
+		if re.MatchString(urlPath) {
+			return true, DENY_REGEXP_PREFIX + re.String()
+		}
+	}
+	return false, ""
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// waitForLog Waits for the log handler to record message about requestURI, returning the matching entries
+func waitForLog(t *testing.T, message, requestURI string) []observer.LoggedEntry {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		entries := testLogs.FilterMessage(message).FilterField(zap.String("request_uri", requestURI)).All()
+		if len(entries) > 0 || time.Now().After(deadline) {
+			return entries
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAccessRules(t *testing.T) {
+	files := map[string]string{
+		".env":                        "secret",
+		".git/config":                 "secret",
+		"docs/.htpasswd":              "secret",
+		".well-known/security.txt":    "contact",
+		"backup.sql":                  "secret",
+		"app.js":                      "app",
+		"app.js.map":                  "map",
+		"private/report.txt":          "secret",
+		"public/private-note.txt":     "note",
+		".well-known/.hidden/key.txt": "secret",
+	}
+
+	tests := []struct {
+		name   string
+		access Access
+		path   string
+		status int
+	}{
+		{"regular file", Access{}, "/app.js", 200},
+		{"dotfile", Access{}, "/.env", 404},
+		{"dot directory", Access{}, "/.git/config", 404},
+		{"nested dotfile", Access{}, "/docs/.htpasswd", 404},
+		{"encoded dot", Access{}, "/%2eenv", 404},
+		{"encoded dot directory", Access{}, "/%2Egit/config", 404},
+		{"dotfile reached through dot segments", Access{}, "/docs/../.env", 404},
+		{"allowlisted", Access{}, "/.well-known/security.txt", 200},
+		{"dotfile below an allowlisted directory", Access{}, "/.well-known/.hidden/key.txt", 404},
+		{"custom allowlist", Access{DotfilesAllowlist: []string{".git"}}, "/.well-known/security.txt", 404},
+		{"dotfiles allowed", Access{AllowDotfiles: true}, "/.env", 200},
+		{"glob on the last segment", Access{Deny: []string{"*.sql"}}, "/backup.sql", 404},
+		{"glob on a directory", Access{Deny: []string{"private"}}, "/private/report.txt", 404},
+		{"glob on whole segments only", Access{Deny: []string{"private"}}, "/public/private-note.txt", 200},
+		{"glob not matching", Access{Deny: []string{"*.sql"}}, "/app.js", 200},
+		{"regexp on the whole path", Access{Deny: []string{`regexp:\.map$`}}, "/app.js.map", 404},
+		{"regexp across segments", Access{Deny: []string{`regexp:^/private/`}}, "/private/report.txt", 404},
+		{"regexp not matching", Access{Deny: []string{`regexp:\.map$`}}, "/app.js", 200},
+		{"glob is not a regexp", Access{Deny: []string{`\.map$`}}, "/app.js.map", 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.Minosse.Access = test.access
+			writeTestFiles(t, conf.Minosse.WebRoot, files)
+			addr := startTestServer(t)
+
+			res := fetch(t, addr, rawRequest("GET", test.path))
+			if res.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", res.StatusCode, test.status)
+			}
+			if test.status == 404 && res.body == "secret" {
+				t.Errorf("denied file served")
+			}
+		})
+	}
+}
+
+func TestAccessRulesLog(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Access = Access{Deny: []string{"*.sql"}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{".env": "secret", "dump.sql": "secret"})
+	addr := startTestServer(t)
+	// Forgets what previous tests logged about the same paths
+	testLogs.TakeAll()
+
+	tests := []struct {
+		target string
+		rule   string
+	}{
+		{"/%2eenv", "dotfile"},
+		{"/dump.sql", "*.sql"},
+	}
+	for _, test := range tests {
+		fetch(t, addr, rawRequest("GET", test.target))
+		entries := waitForLog(t, "Denied access to request path", test.target)
+		if len(entries) != 1 {
+			t.Errorf("%s: got %d log entries, want 1", test.target, len(entries))
+			continue
+		}
+		if entries[0].Level != zapcore.WarnLevel || entries[0].ContextMap()["rule"] != test.rule {
+			t.Errorf("%s: got a %s entry for rule %v, want a warning for %s", test.target, entries[0].Level, entries[0].ContextMap()["rule"], test.rule)
+		}
+	}
+}
+
+func TestInvalidDenyRules(t *testing.T) {
+	for _, rule := range []string{"[", "regexp:("} {
+		if _, err := newAccessRules(Access{Deny: []string{rule}}); err == nil {
+			t.Errorf("%q: no error for an invalid rule", rule)
+		}
+	}
+}
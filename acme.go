@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeManager obtains and renews certificates for acmeHosts, nil when ACME is disabled
+var acmeManager *autocert.Manager
+
+// acmeHTTPHandler answers HTTP-01 challenges. The ACME client only offers HTTP-01 once its handler exists
+var acmeHTTPHandler http.Handler
+var acmeHosts = make(map[string]bool)
+
+var errNoCertificate = errors.New("no certificate available for the requested server name")
+
+// newACMEManager Prepares the ACME client. Certificates are requested lazily, during the first handshake asking for
+// each host, and renewed in background before they expire
+func newACMEManager(conf *ACME) *autocert.Manager {
+	hosts := conf.Hosts
+	if len(hosts) == 0 {
+		// Wildcard certificates need DNS-01 challenges, which are not supported
+		for _, vh := range virtualHosts {
+			for _, host := range vh.Hosts {
+				if !strings.HasPrefix(host, "*.") {
+					hosts = append(hosts, host)
+				}
+			}
+		}
+	}
+	if len(hosts) == 0 {
+		logChannel.fatalError("ACME is enabled, but no host was specified in current configuration", nil)
+	}
+	for _, host := range hosts {
+		acmeHosts[normalizeHost(host)] = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.RootCAPath != "" {
+		// Test servers such as Pebble serve their directory with a certificate signed by their own CA
+		rootCA, err := ioutil.ReadFile(conf.RootCAPath)
+		if err != nil {
+			logChannel.fatalError("Could not read ACME root CA file", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(rootCA) {
+			logChannel.fatalError("Unable to use supplied ACME root CA cert. Make sure it is a valid certificate", nil)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+	httpClient := &http.Client{Transport: &orderLocationTransport{transport: transport, orders: make(map[string]string)}}
+
+	logChannel.channel <- Log{level: INFO, message: "Obtaining certificates through ACME", data: []zap.Field{zap.String("directory", conf.DirectoryURL), zap.Strings("hosts", hosts)}}
+	manager := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(conf.CacheDir),
+		HostPolicy: func(_ context.Context, host string) error {
+			if !acmeHosts[normalizeHost(host)] {
+				return errors.New("acme: host " + host + " is not configured")
+			}
+			return nil
+		},
+		Client: &acme.Client{DirectoryURL: conf.DirectoryURL, HTTPClient: httpClient},
+		Email:  conf.Email,
+	}
+	acmeHTTPHandler = manager.HTTPHandler(nil)
+	return manager
+}
+
+// orderLocationTransport Adds the order URL to finalization responses lacking it. The ACME client relies on the Location
+// header of those responses to poll orders still being processed, but some servers (e.g. Pebble) omit it. Order URLs
+// are learnt from the responses creating orders, which carry both the Location header and the finalize URL
+type orderLocationTransport struct {
+	transport http.RoundTripper
+	mutex     sync.Mutex
+	// orders maps finalize URLs to order URLs
+	orders map[string]string
+}
+
+func (t *orderLocationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost {
+		return res, err
+	}
+
+	location := res.Header.Get(HEADER_LOCATION)
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if orderURL, ok := t.orders[req.URL.String()]; ok {
+		delete(t.orders, req.URL.String())
+		if location == "" {
+			res.Header.Set(HEADER_LOCATION, orderURL)
+		}
+		return res, nil
+	}
+	if location == "" || res.StatusCode != http.StatusCreated {
+		return res, nil
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	var order struct {
+		Finalize string
+	}
+	if json.Unmarshal(body, &order) == nil && order.Finalize != "" {
+		t.orders[order.Finalize] = location
+	}
+	return res, nil
+}
+
+// acmeCertificate Reports whether the certificate for a handshake comes from ACME: either the client is an ACME server
+// validating a TLS-ALPN-01 challenge, or it asks for one of the ACME hosts
+func acmeCertificate(hello *tls.ClientHelloInfo) bool {
+	if acmeManager == nil {
+		return false
+	}
+	if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+		return true
+	}
+	return acmeHosts[normalizeHost(hello.ServerName)]
+}
+
+// acmeChallenge Reports whether the request is an ACME HTTP-01 challenge to be answered by the ACME client
+func acmeChallenge(req *http.Request) bool {
+	return acmeManager != nil && req.TLS == nil && strings.HasPrefix(req.URL.Path, ACME_CHALLENGE_PREFIX)
+}
+
+// serveACMEChallenge Answers an HTTP-01 challenge through the handler of the ACME client
+func serveACMEChallenge(req *http.Request) Response {
+	recorder := &responseRecorder{header: make(http.Header)}
+	acmeHTTPHandler.ServeHTTP(recorder, req)
+	return recorder.response()
+}
+
+// responseRecorder A minimal http.ResponseWriter, collecting what a net/http handler writes into a Response
+type responseRecorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
+}
+
+func (r *responseRecorder) response() Response {
+	if r.statusCode == 0 {
+		r.statusCode = http.StatusOK
+	}
+	headers := make(map[string]string)
+	for key := range r.header {
+		headers[key] = r.header.Get(key)
+	}
+	headers[HEADER_CONTENT_LENGTH] = strconv.Itoa(r.body.Len())
+	return Response{
+		status:     http.StatusText(r.statusCode),
+		statusCode: r.statusCode,
+		body:       r.body.Bytes(),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(headers, HeaderMapToString),
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestOrderLocationTransport(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new-order":
+			w.Header().Set("Location", server.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"status": "pending", "finalize": server.URL + "/finalize/1"})
+		case "/new-order-without-finalize":
+			w.Header().Set("Location", server.URL+"/order/2")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"status": "pending"}`))
+		case "/finalize/1":
+			// Like Pebble, no Location header
+			w.Write([]byte(`{"status": "processing"}`))
+		case "/finalize/3":
+			w.Header().Set("Location", server.URL+"/order/3")
+			w.Write([]byte(`{"status": "processing"}`))
+		}
+	}))
+	defer server.Close()
+	transport := &orderLocationTransport{transport: http.DefaultTransport, orders: make(map[string]string)}
+	client := &http.Client{Transport: transport}
+
+	post := func(path string) *http.Response {
+		t.Helper()
+		res, err := client.Post(server.URL+path, "application/jose+json", strings.NewReader("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	// The body of the response creating the order is still readable after being inspected
+	res, err := client.Post(server.URL+"/new-order", "application/jose+json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "/finalize/1") {
+		t.Errorf("got order %q, want the finalize URL", body)
+	}
+	if location := post("/finalize/1").Header.Get("Location"); location != server.URL+"/order/1" {
+		t.Errorf("finalization: got Location %q, want the order URL", location)
+	}
+	// Finalize URLs are forgotten once used
+	if len(transport.orders) != 0 {
+		t.Errorf("got pending orders %v, want none", transport.orders)
+	}
+	if location := post("/finalize/1").Header.Get("Location"); location != "" {
+		t.Errorf("second finalization: got Location %q, want none", location)
+	}
+
+	post("/new-order-without-finalize")
+	if len(transport.orders) != 0 {
+		t.Errorf("got pending orders %v for an order without finalize URL", transport.orders)
+	}
+	transport.orders[server.URL+"/finalize/3"] = server.URL + "/order/other"
+	if location := post("/finalize/3").Header.Get("Location"); location != server.URL+"/order/3" {
+		t.Errorf("got Location %q, want the one sent by the server", location)
+	}
+}
+
+func TestACMEChallengeRouting(t *testing.T) {
+	previousManager, previousHosts := acmeManager, acmeHosts
+	t.Cleanup(func() { acmeManager, acmeHosts = previousManager, previousHosts })
+
+	challenge := &http.Request{URL: mustParseURL(t, ACME_CHALLENGE_PREFIX+"token")}
+	hello := &tls.ClientHelloInfo{ServerName: "acme.test"}
+	validation := &tls.ClientHelloInfo{ServerName: "other.test", SupportedProtos: []string{"acme-tls/1"}}
+	acmeManager, acmeHosts = nil, map[string]bool{"acme.test": true}
+	if acmeChallenge(challenge) || acmeCertificate(hello) || acmeCertificate(validation) {
+		t.Errorf("ACME requests routed to the ACME client while disabled")
+	}
+
+	newACMEManagerForTest(t, &ACME{DirectoryURL: "https://acme.invalid/directory", CacheDir: t.TempDir(), Hosts: []string{"acme.test"}})
+	if !acmeChallenge(challenge) {
+		t.Errorf("HTTP-01 challenge not routed to the ACME client")
+	}
+	if acmeChallenge(&http.Request{URL: challenge.URL, TLS: &tls.ConnectionState{}}) {
+		t.Errorf("HTTP-01 challenge over TLS routed to the ACME client")
+	}
+	if !acmeCertificate(hello) || !acmeCertificate(&tls.ClientHelloInfo{ServerName: "ACME.test."}) {
+		t.Errorf("ACME host not served with an ACME certificate")
+	}
+	if !acmeCertificate(validation) {
+		t.Errorf("TLS-ALPN-01 validation not served by the ACME client")
+	}
+	if acmeCertificate(&tls.ClientHelloInfo{ServerName: "other.test", SupportedProtos: []string{"http/1.1", "acme-tls/1"}}) {
+		t.Errorf("regular handshake for another host served by the ACME client")
+	}
+	if res := serveACMEChallenge(&http.Request{Method: "GET", Host: "acme.test", URL: mustParseURL(t, ACME_CHALLENGE_PREFIX+"unknown")}); res.statusCode != 404 {
+		t.Errorf("unknown challenge token: got %d, want 404", res.statusCode)
+	}
+}
+
+// newACMEManagerForTest Enables the ACME client until the end of the test
+func newACMEManagerForTest(t *testing.T, conf *ACME) {
+	previousManager, previousHosts, previousHandler := acmeManager, acmeHosts, acmeHTTPHandler
+	t.Cleanup(func() { acmeManager, acmeHosts, acmeHTTPHandler = previousManager, previousHosts, previousHandler })
+	acmeHosts = make(map[string]bool)
+	acmeManager = newACMEManager(conf)
+}
+
+// TestACMEPebble Obtains certificates from a running Pebble test CA (https://github.com/letsencrypt/pebble), through
+// both challenge types. Skipped unless MINOSSE_PEBBLE_DIRECTORY (e.g. https://localhost:14000/dir) and
+// MINOSSE_PEBBLE_ROOT_CA (the pebble.minica.pem certificate Pebble serves its directory with) are set. The ports Pebble
+// validates challenges on are its httpPort and tlsPort, 5002 and 5001 unless overridden through
+// MINOSSE_PEBBLE_HTTP_PORT and MINOSSE_PEBBLE_TLS_PORT. Certificates are requested for MINOSSE_PEBBLE_HOST (defaults
+// to acme.test), which has to resolve to the loopback interface for Pebble, e.g. through /etc/hosts
+func TestACMEPebble(t *testing.T) {
+	directory, rootCA := os.Getenv("MINOSSE_PEBBLE_DIRECTORY"), os.Getenv("MINOSSE_PEBBLE_ROOT_CA")
+	if directory == "" || rootCA == "" {
+		t.Skip("MINOSSE_PEBBLE_DIRECTORY and MINOSSE_PEBBLE_ROOT_CA not set")
+	}
+	host := envOrDefault("MINOSSE_PEBBLE_HOST", "acme.test")
+
+	tests := []struct {
+		challenge string
+		port      string
+	}{
+		// Nothing listens on the TLS port, so that TLS-ALPN-01, tried first, fails
+		{"http-01", envOrDefault("MINOSSE_PEBBLE_HTTP_PORT", "5002")},
+		// Nothing listens on the plain port
+		{"tls-alpn-01", envOrDefault("MINOSSE_PEBBLE_TLS_PORT", "5001")},
+	}
+	for _, test := range tests {
+		t.Run(test.challenge, func(t *testing.T) {
+			conf := newTestConfig(t)
+			resetCertificates(t)
+			// A new account every time, so that Pebble does not reuse the authorization obtained by the previous one
+			conf.Minosse.TLS = TLS{Enabled: true, MinVersion: "1.2", ACME: ACME{Enabled: true, DirectoryURL: directory, CacheDir: t.TempDir(), Hosts: []string{host}, RootCAPath: rootCA}}
+			newACMEManagerForTest(t, &conf.Minosse.TLS.ACME)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:"+test.port)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.challenge == "tls-alpn-01" {
+				listener = tls.NewListener(listener, newTLSConfig(&conf.Minosse.TLS))
+			}
+			serveTestListener(t, listener)
+
+			// The certificate is obtained during the first handshake asking for the host
+			cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: host})
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := leaf.VerifyHostname(host); err != nil || !strings.Contains(leaf.Issuer.CommonName, "Pebble") {
+				t.Errorf("got a certificate issued by %q (%v), want one from Pebble for %s", leaf.Issuer.CommonName, err, host)
+			}
+		})
+	}
+}
+
+// mustParseURL Parses a request target
+func mustParseURL(t *testing.T, target string) *url.URL {
+	t.Helper()
+	u, err := url.ParseRequestURI(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// envOrDefault Returns the environment variable key, or value when unset
+func envOrDefault(key, value string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return value
+}
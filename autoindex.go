@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// autoindexEntry A single file or directory of a listing
+type autoindexEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Dir     bool      `json:"dir"`
+}
+
+// autoindexEnabled Reports whether listings are enabled for the directory addressed by dirURLPath, which is cleaned
+// first so that "/pub/../secret/" is not taken for a directory below "/pub/"
+func (vh *virtualHost) autoindexEnabled(dirURLPath string) bool {
+	if !vh.Autoindex.Enabled {
+		return false
+	}
+	for _, prefix := range vh.Autoindex.Prefixes {
+		if pathHasPrefix(dirURLPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readAutoindexEntries Reads the entries of a directory, skipping the ones hidden by access rules or not allowed by
+// the symlink policy. Directories are read in batches, and only name, size and modification time are retained
+func (vh *virtualHost) readAutoindexEntries(dirPath, dirURLPath string) ([]autoindexEntry, error) {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	var entries []autoindexEntry
+	for {
+		infos, err := dir.Readdir(AutoindexReadBatch)
+		for _, info := range infos {
+			entryURLPath := dirURLPath + info.Name()
+			if denied, _ := vh.accessControl.denied(entryURLPath); denied {
+				continue
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := vh.resolver.resolvePath(entryURLPath)
+				if err != nil {
+					continue
+				}
+				if info, err = os.Stat(target); err != nil {
+					continue
+				}
+			}
+			entries = append(entries, autoindexEntry{Name: info.Name(), Size: info.Size(), ModTime: info.ModTime(), Dir: info.IsDir()})
+		}
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sortAutoindexEntries Sorts entries according to the "sort" (name, size or mtime) and "order" (asc or desc) query
+// parameters. Directories are always listed first
+func sortAutoindexEntries(entries []autoindexEntry, query url.Values) {
+	desc := query.Get("order") == "desc"
+	var less func(a, b *autoindexEntry) bool
+	switch query.Get("sort") {
+	case "size":
+		less = func(a, b *autoindexEntry) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b *autoindexEntry) bool { return a.ModTime.Before(b.ModTime) }
+	default:
+		less = func(a, b *autoindexEntry) bool { return a.Name < b.Name }
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := &entries[i], &entries[j]
+		if a.Dir != b.Dir {
+			return a.Dir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+}
+
+// serveAutoindex Streams the listing of a directory, as HTML or as JSON when the client asks for application/json
+func (vh *virtualHost) serveAutoindex(w *bufio.Writer, req *http.Request, dirPath string, keepAlive bool) (Response, bool) {
+	var response Response
+	entries, err := vh.readAutoindexEntries(dirPath, req.URL.Path)
+	if err != nil {
+		logChannel.error("Error while reading directory", err)
+		response = ResponseInternalServerError()
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+	sortAutoindexEntries(entries, req.URL.Query())
+
+	asJSON := strings.Contains(req.Header.Get(HEADER_ACCEPT), MIME_JSON)
+	contentType := "text/html; charset=utf-8"
+	if asJSON {
+		contentType = MIME_JSON
+	}
+	headers := map[string]string{HEADER_CONTENT_TYPE: contentType, HEADER_CACHE_CONTROL: "no-cache", HEADER_VARY: HEADER_ACCEPT, HEADER_DATE: time.Now().UTC().Format(http.TimeFormat), HEADER_SERVER: HEADER_SERVER_VALUE}
+
+	response, stream, keepAlive, err := startStream(w, req, headers, keepAlive)
+	if err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+	if req.Method == HTTP_HEAD_METHOD {
+		return response, keepAlive
+	}
+
+	// Entries are small: buffering them avoids sending one chunk each
+	out := bufio.NewWriterSize(stream, AutoindexBufferSize)
+	if asJSON {
+		err = writeAutoindexJSON(out, entries)
+	} else {
+		err = writeAutoindexHTML(out, req.URL.Path, entries)
+	}
+	if err == nil {
+		err = out.Flush()
+	}
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+	return response, keepAlive
+}
+
+func writeAutoindexJSON(out *bufio.Writer, entries []autoindexEntry) error {
+	out.WriteString("[")
+	for i := range entries {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		entry, err := json.Marshal(&entries[i])
+		if err != nil {
+			return err
+		}
+		if _, err := out.Write(entry); err != nil {
+			return err
+		}
+	}
+	_, err := out.WriteString("]")
+	return err
+}
+
+func writeAutoindexHTML(out *bufio.Writer, dirURLPath string, entries []autoindexEntry) error {
+	title := html.EscapeString("Index of " + dirURLPath)
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>" + title + "</title></head>\n<body>\n<h1>" + title + "</h1>\n")
+	out.WriteString("<table>\n<tr><th><a href=\"?sort=name\">Name</a></th><th><a href=\"?sort=size\">Size</a></th><th><a href=\"?sort=mtime\">Last modified</a></th></tr>\n")
+	if dirURLPath != "/" {
+		out.WriteString("<tr><td><a href=\"../\">../</a></td><td>-</td><td></td></tr>\n")
+	}
+
+	for _, entry := range entries {
+		name, size := entry.Name, strconv.FormatInt(entry.Size, 10)
+		if entry.Dir {
+			name, size = name+"/", "-"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		// A name like "a:b" would otherwise be taken for an absolute URL with scheme "a"
+		if strings.Contains(name, ":") {
+			href = "./" + href
+		}
+		if _, err := out.WriteString("<tr><td><a href=\"" + html.EscapeString(href) + "\">" + html.EscapeString(name) + "</a></td><td>" + size + "</td><td>" + entry.ModTime.UTC().Format(http.TimeFormat) + "</td></tr>\n"); err != nil {
+			return err
+		}
+	}
+	_, err := out.WriteString("</table>\n</body>\n</html>\n")
+	return err
+}
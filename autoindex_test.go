@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSortAutoindexEntries(t *testing.T) {
+	now := time.Now()
+	entries := []autoindexEntry{
+		{Name: "b.txt", Size: 1, ModTime: now},
+		{Name: "z", Dir: true, ModTime: now.Add(-time.Hour)},
+		{Name: "a.txt", Size: 3, ModTime: now.Add(-2 * time.Hour)},
+		{Name: "c.txt", Size: 2, ModTime: now.Add(-time.Minute)},
+		{Name: "m", Dir: true, ModTime: now},
+	}
+
+	tests := []struct {
+		query string
+		names string
+	}{
+		{"", "m,z,a.txt,b.txt,c.txt"},
+		{"sort=name&order=desc", "z,m,c.txt,b.txt,a.txt"},
+		// The sort is stable: directories, all of size 0, keep their order
+		{"sort=size", "z,m,b.txt,c.txt,a.txt"},
+		{"sort=size&order=desc", "z,m,a.txt,c.txt,b.txt"},
+		{"sort=mtime", "z,m,a.txt,c.txt,b.txt"},
+		{"sort=mtime&order=desc", "m,z,b.txt,c.txt,a.txt"},
+		{"sort=unknown", "m,z,a.txt,b.txt,c.txt"},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		sorted := append([]autoindexEntry(nil), entries...)
+		sortAutoindexEntries(sorted, query)
+		var names []string
+		for _, entry := range sorted {
+			names = append(names, entry.Name)
+		}
+		if got := strings.Join(names, ","); got != test.names {
+			t.Errorf("?%s: got %s, want %s", test.query, got, test.names)
+		}
+	}
+}
+
+func TestAutoindex(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Autoindex = Autoindex{Enabled: true}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{
+		"files/a.txt":                 "aaa",
+		"files/<b>.txt":               "b",
+		"files/c:d.txt":               "c",
+		"files/.hidden":               "secret",
+		"files/sub/x.txt":             "x",
+		"files/with index/a.txt":      "a",
+		"files/with index/index.html": "index",
+	})
+	if err := os.Symlink(t.TempDir(), filepath.Join(conf.Minosse.WebRoot, "files", "outside")); err != nil {
+		t.Fatal(err)
+	}
+	conf.Minosse.Symlinks = SYMLINKS_DENY
+	addr := startTestServer(t)
+
+	t.Run("HTML", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("GET", "/files/"))
+		if res.StatusCode != 200 || res.Header.Get("Content-Type") != "text/html; charset=utf-8" || len(res.TransferEncoding) == 0 {
+			t.Fatalf("got %d with Content-Type %q and Transfer-Encoding %v, want a chunked HTML listing", res.StatusCode, res.Header.Get("Content-Type"), res.TransferEncoding)
+		}
+		for _, want := range []string{
+			"<title>Index of /files/</title>",
+			`<a href="../">../</a>`,
+			`<a href="a.txt">a.txt</a></td><td>3</td>`,
+			`<a href="%3Cb%3E.txt">&lt;b&gt;.txt</a>`,
+			`<a href="./c:d.txt">c:d.txt</a>`,
+			`<a href="sub/">sub/</a></td><td>-</td>`,
+		} {
+			if !strings.Contains(res.body, want) {
+				t.Errorf("listing does not contain %s", want)
+			}
+		}
+		for _, hidden := range []string{".hidden", "outside"} {
+			if strings.Contains(res.body, hidden) {
+				t.Errorf("listing contains %s", hidden)
+			}
+		}
+		if strings.Index(res.body, "sub/") > strings.Index(res.body, "a.txt") {
+			t.Errorf("directories not listed first")
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("GET", "/files/?sort=size&order=desc", "Accept: application/json"))
+		if res.StatusCode != 200 || res.Header.Get("Content-Type") != "application/json" || res.Header.Get("Vary") != "Accept" {
+			t.Fatalf("got %d with Content-Type %q and Vary %q, want a JSON listing", res.StatusCode, res.Header.Get("Content-Type"), res.Header.Get("Vary"))
+		}
+		var entries []autoindexEntry
+		if err := json.Unmarshal([]byte(res.body), &entries); err != nil {
+			t.Fatalf("invalid JSON %q: %v", res.body, err)
+		}
+		if len(entries) != 5 || !entries[0].Dir || entries[2].Name != "a.txt" || entries[2].Size != 3 {
+			t.Errorf("got entries %+v", entries)
+		}
+	})
+
+	t.Run("index file wins", func(t *testing.T) {
+		if res := fetch(t, addr, rawRequest("GET", "/files/with%20index/")); res.body != "index" {
+			t.Errorf("got %q, want the index file", res.body)
+		}
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("HEAD", "/files/"))
+		if res.StatusCode != 200 || res.body != "" {
+			t.Errorf("got %d with body %q, want 200 without body", res.StatusCode, res.body)
+		}
+	})
+
+	t.Run("HTTP/1.0", func(t *testing.T) {
+		res := fetch(t, addr, "GET /files/ HTTP/1.0\r\n\r\n")
+		if len(res.TransferEncoding) > 0 || !res.Close || !strings.Contains(res.body, "</html>") {
+			t.Errorf("got Transfer-Encoding %v and close %t, want the listing delimited by the connection close", res.TransferEncoding, res.Close)
+		}
+	})
+}
+
+func TestAutoindexPrefixes(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Autoindex = Autoindex{Enabled: true, Prefixes: []string{"/pub/"}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"pub/a.txt": "a", "pub/sub/b.txt": "b", "pubx/c.txt": "c", "secret/key.txt": "key"})
+	addr := startTestServer(t)
+
+	tests := []struct {
+		path    string
+		status  int
+		listing string
+	}{
+		{"/pub/", 200, "a.txt"},
+		{"/pub/sub/", 200, "b.txt"},
+		{"/secret/", 403, ""},
+		// Prefixes are matched against the cleaned path, on whole segments
+		{"/pub/../secret/", 403, ""},
+		{"/pub/%2e%2e/secret/", 403, ""},
+		{"//secret/", 403, ""},
+		{"/pubx/", 403, ""},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, rawRequest("GET", test.path))
+		if res.StatusCode != test.status {
+			t.Errorf("GET %s: got status %d, want %d", test.path, res.StatusCode, test.status)
+		}
+		if test.listing != "" && !strings.Contains(res.body, test.listing) {
+			t.Errorf("GET %s: listing does not contain %s", test.path, test.listing)
+		}
+		if strings.Contains(res.body, "key.txt") {
+			t.Errorf("GET %s: listed a directory outside of the autoindex prefixes", test.path)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// lruCache A least recently used cache bounded both in number of entries and in total size, safe for concurrent use
+// by all the workers
+type lruCache struct {
+	mutex      sync.Mutex
+	maxBytes   int64
+	maxEntries int
+	bytes      int64
+	entries    map[string]*list.Element
+	order      *list.List
+	hits       uint64
+	misses     uint64
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+func newLRUCache(maxBytes int64, maxEntries int) *lruCache {
+	return &lruCache{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// get Returns the value stored under key, marking it as the most recently used
+func (c *lruCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).value, true
+}
+
+// peek Returns the value stored under key, leaving the order of the entries untouched. Meant for background checks,
+// which must not keep entries alive
+func (c *lruCache) peek(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return element.Value.(*lruEntry).value, true
+}
+
+// add Stores value under key, evicting the least recently used entries until the cache fits its bounds again.
+// Values bigger than the whole cache are not stored at all
+func (c *lruCache) add(key string, value interface{}, size int64) {
+	if size > c.maxBytes {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, size: size})
+	c.bytes += size
+
+	for c.bytes > c.maxBytes || len(c.entries) > c.maxEntries {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// removeIf Removes every entry whose key satisfies the predicate
+func (c *lruCache) removeIf(predicate func(key string) bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key, element := range c.entries {
+		if predicate(key) {
+			c.removeElement(element)
+		}
+	}
+}
+
+// keys Returns a snapshot of the keys currently in the cache, most recently used first
+func (c *lruCache) keys() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	keys := make([]string, 0, len(c.entries))
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*lruEntry).key)
+	}
+	return keys
+}
+
+func (c *lruCache) removeElement(element *list.Element) {
+	entry := c.order.Remove(element).(*lruEntry)
+	delete(c.entries, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *lruCache) hit() {
+	atomic.AddUint64(&c.hits, 1)
+}
+
+func (c *lruCache) miss() {
+	atomic.AddUint64(&c.misses, 1)
+}
+
+// logStats Periodically logs hit/miss counters and occupation of the cache, whenever they changed
+func (c *lruCache) logStats(name string, interval time.Duration) {
+	var lastHits, lastMisses uint64
+	for range time.Tick(interval) {
+		hits, misses := atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+		if hits == lastHits && misses == lastMisses {
+			continue
+		}
+		lastHits, lastMisses = hits, misses
+
+		c.mutex.Lock()
+		entries, bytes := len(c.entries), c.bytes
+		c.mutex.Unlock()
+		logChannel.channel <- Log{
+			level:   INFO,
+			message: "Cache statistics",
+			data:    []zap.Field{zap.String("cache", name), zap.Uint64("hits", hits), zap.Uint64("misses", misses), zap.Int("entries", entries), zap.Int64("bytes", bytes)},
+		}
+	}
+}
+
+// compressedAsset A compressed body, along with the state of the file it was generated from
+type compressedAsset struct {
+	modTime time.Time
+	size    int64
+	body    []byte
+}
+
+var compressedCache *lruCache
+
+// getCompressed Looks up the compressed body of pathFile in the given encoding. Entries generated from a different
+// version of the file (according to its size and modification time) are dropped
+func getCompressed(pathFile string, stat os.FileInfo, encoding string) ([]byte, bool) {
+	if compressedCache == nil {
+		return nil, false
+	}
+	key := pathFile + "\x00" + encoding
+	value, ok := compressedCache.get(key)
+	if ok {
+		asset := value.(*compressedAsset)
+		if asset.modTime.Equal(stat.ModTime()) && asset.size == stat.Size() {
+			compressedCache.hit()
+			return asset.body, true
+		}
+		compressedCache.remove(key)
+	}
+	compressedCache.miss()
+	return nil, false
+}
+
+func putCompressed(pathFile string, stat os.FileInfo, encoding string, body []byte) {
+	if compressedCache == nil {
+		return
+	}
+	compressedCache.add(pathFile+"\x00"+encoding, &compressedAsset{modTime: stat.ModTime(), size: stat.Size(), body: body}, int64(len(body)))
+}
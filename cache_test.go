@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	keys := func(c *lruCache) string {
+		var keys []string
+		for element := c.order.Front(); element != nil; element = element.Next() {
+			keys = append(keys, element.Value.(*lruEntry).key)
+		}
+		return strings.Join(keys, ",")
+	}
+
+	c := newLRUCache(10, 3)
+	c.add("a", 1, 2)
+	c.add("b", 2, 2)
+	c.add("c", 3, 2)
+	if got := keys(c); got != "c,b,a" {
+		t.Fatalf("got order %s, want c,b,a", got)
+	}
+
+	// Peeking leaves the order untouched
+	if value, ok := c.peek("a"); !ok || value.(int) != 1 || keys(c) != "c,b,a" {
+		t.Fatalf("peek(a) = %v, %t with order %s, want 1, true with c,b,a", value, ok, keys(c))
+	}
+	if _, ok := c.peek("missing"); ok {
+		t.Errorf("peek found a missing key")
+	}
+
+	// A lookup promotes the entry, so that the least recently used one is evicted first
+	if value, ok := c.get("a"); !ok || value.(int) != 1 {
+		t.Fatalf("get(a) = %v, %t", value, ok)
+	}
+	c.add("d", 4, 2)
+	if got := keys(c); got != "d,a,c" {
+		t.Errorf("entries bound: got %s, want d,a,c", got)
+	}
+
+	c.add("e", 5, 7)
+	if got := keys(c); got != "e,d" || c.bytes != 9 {
+		t.Errorf("size bound: got %s with %d bytes, want e,d with 9", got, c.bytes)
+	}
+
+	c.add("d", 6, 1)
+	if value, _ := c.get("d"); value.(int) != 6 || c.bytes != 8 || len(c.entries) != 2 {
+		t.Errorf("replacing an entry: got %v with %d bytes and %d entries", value, c.bytes, len(c.entries))
+	}
+
+	c.add("huge", 7, 11)
+	if _, ok := c.get("huge"); ok || keys(c) != "d,e" {
+		t.Errorf("a value bigger than the cache was stored: %s", keys(c))
+	}
+
+	c.remove("e")
+	c.remove("missing")
+	if _, ok := c.get("e"); ok || c.bytes != 1 {
+		t.Errorf("remove left %s with %d bytes", keys(c), c.bytes)
+	}
+}
+
+func TestCompressedCache(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Compression = Compression{Enabled: true, Encodings: []string{GZIP}, Cache: CompressionCache{Enabled: true}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": strings.Repeat("first ", 500)})
+	addr := startTestServer(t)
+	compressedCache = newLRUCache(conf.Minosse.Compression.Cache.MaxBytes, conf.Minosse.Compression.Cache.MaxEntries)
+	t.Cleanup(func() { compressedCache = nil })
+
+	get := func(want string) {
+		t.Helper()
+		res := fetch(t, addr, rawRequest("GET", "/a.txt", "Accept-Encoding: gzip"))
+		if res.Header.Get("Content-Encoding") != GZIP || gunzip(t, res.body) != want {
+			t.Fatalf("got Content-Encoding %q and a different body, want gzip", res.Header.Get("Content-Encoding"))
+		}
+	}
+	get(strings.Repeat("first ", 500))
+	get(strings.Repeat("first ", 500))
+	if hits, misses := atomic.LoadUint64(&compressedCache.hits), atomic.LoadUint64(&compressedCache.misses); hits != 1 || misses != 1 {
+		t.Errorf("got %d hits and %d misses, want 1 and 1", hits, misses)
+	}
+
+	// A different version of the file replaces the cached body
+	path := filepath.Join(conf.Minosse.WebRoot, "a.txt")
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": strings.Repeat("second ", 500)})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	get(strings.Repeat("second ", 500))
+	if len(compressedCache.entries) != 1 {
+		t.Errorf("got %d cached entries, want 1", len(compressedCache.entries))
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// GEN_CERT_COMMAND name of the subcommand generating a local root CA and a server certificate
+const GEN_CERT_COMMAND string = "gen-cert"
+
+var errNoHosts = errors.New("at least one hostname or IP address is needed")
+
+// genCert Implements "minosse gen-cert": creates a root CA (unless one exists already) and a server certificate signed
+// by it, for the given hostnames and IP addresses. Files are written where [minosse.tls] expects them, unless
+// different paths are given. Returns the process exit code
+func genCert(args []string) int {
+	flags := flag.NewFlagSet(GEN_CERT_COMMAND, flag.ContinueOnError)
+	configPath := flags.String("config", CONFIG_FILE_PATH, "configuration file the default paths are read from")
+	hosts := flags.String("hosts", "localhost,127.0.0.1,::1", "comma separated hostnames and IP addresses of the server certificate")
+	certPath := flags.String("cert", "", "server certificate path (default [minosse.tls] x509CertPath, or private/server.crt)")
+	keyPath := flags.String("key", "", "server private key path (default [minosse.tls] x509KeyPath, or private/server.key)")
+	caCertPath := flags.String("ca-cert", "", "root CA certificate path (default [minosse.tls] x509RootCAPath, or private/rootCA.pem)")
+	caKeyPath := flags.String("ca-key", "", "root CA private key path (default the root CA certificate path with a .key extension)")
+	days := flags.Int("days", 825, "validity of the server certificate, in days")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	var conf Config
+	if content, err := ioutil.ReadFile(*configPath); err == nil {
+		if err := toml.Unmarshal(content, &conf); err != nil {
+			fmt.Fprintln(os.Stderr, "Error in minosse configuration file:", err)
+			return 1
+		}
+	}
+	*certPath = firstNonEmpty(*certPath, conf.Minosse.TLS.X509CertPath, "private/server.crt")
+	*keyPath = firstNonEmpty(*keyPath, conf.Minosse.TLS.X509KeyPath, "private/server.key")
+	*caCertPath = firstNonEmpty(*caCertPath, conf.Minosse.TLS.X509RootCAPath, "private/rootCA.pem")
+	*caKeyPath = firstNonEmpty(*caKeyPath, strings.TrimSuffix(*caCertPath, filepath.Ext(*caCertPath))+".key")
+
+	template, err := serverCertificateTemplate(strings.Split(*hosts, ","), time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while preparing the server certificate:", err)
+		return 1
+	}
+	ca, caKey, err := loadOrCreateCA(*caCertPath, *caKeyPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while preparing the root CA:", err)
+		return 1
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while generating the server key:", err)
+		return 1
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err == nil {
+		err = writePEM(*certPath, "CERTIFICATE", der, 0644)
+	}
+	if err == nil {
+		err = writeKey(*keyPath, key)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error while writing the server certificate:", err)
+		return 1
+	}
+	fmt.Printf("Server certificate for %s written to %s (key %s), signed by %s\n", *hosts, *certPath, *keyPath, *caCertPath)
+	fmt.Printf("Trust %s in your browser or system store to avoid certificate warnings\n", *caCertPath)
+	return 0
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// loadOrCreateCA Loads the root CA found at the given paths, or creates a new one when there is none, so that server
+// certificates can be regenerated without having to trust a new CA
+func loadOrCreateCA(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	if _, err := os.Stat(certPath); err == nil {
+		pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		ca, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok || !ca.IsCA {
+			return nil, nil, errors.New(certPath + " is not a certificate authority")
+		}
+		return ca, signer, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"Minosse development CA"}, CommonName: "Minosse development root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0644); err != nil {
+		return nil, nil, err
+	}
+	if err := writeKey(keyPath, key); err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// serverCertificateTemplate Template of a server certificate valid for the given hostnames and IP addresses
+func serverCertificateTemplate(hosts []string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := randomSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{Organization: []string{"Minosse development certificate"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(template.DNSNames) == 0 && len(template.IPAddresses) == 0 {
+		return nil, errNoHosts
+	}
+	if len(template.DNSNames) > 0 {
+		template.Subject.CommonName = template.DNSNames[0]
+	}
+	return template, nil
+}
+
+// selfSignedCertificate Generates an in-memory self-signed certificate for the given hostnames and IP addresses
+func selfSignedCertificate(hosts []string) (*tls.Certificate, error) {
+	template, err := serverCertificateTemplate(hosts, SelfSignedCertificateValidity)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+func randomSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func writeKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return writePEM(path, "PRIVATE KEY", der, 0600)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
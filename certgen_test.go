@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readTestCertificate Parses the PEM certificate at path
+func readTestCertificate(t *testing.T, path string) *x509.Certificate {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		t.Fatalf("%s: no PEM block", path)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// verifyTestCertificate Verifies that cert is a server certificate for host, signed by ca
+func verifyTestCertificate(t *testing.T, cert, ca *x509.Certificate, host string) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: host, Roots: roots}); err != nil {
+		t.Errorf("%s: %v", host, err)
+	}
+}
+
+func TestGenCert(t *testing.T) {
+	dir := t.TempDir()
+	path := func(name string) string { return filepath.Join(dir, name) }
+	args := func(hosts string) []string {
+		return []string{"-config", path("missing.toml"), "-hosts", hosts, "-cert", path("server.crt"), "-key", path("server.key"), "-ca-cert", path("ca/rootCA.pem")}
+	}
+
+	if code := genCert(args("example.test, 127.0.0.1,::1")); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	ca := readTestCertificate(t, path("ca/rootCA.pem"))
+	cert := readTestCertificate(t, path("server.crt"))
+	if !ca.IsCA || len(cert.DNSNames) != 1 || len(cert.IPAddresses) != 2 || cert.Subject.CommonName != "example.test" {
+		t.Errorf("got CA %t, DNS names %v and IP addresses %v", ca.IsCA, cert.DNSNames, cert.IPAddresses)
+	}
+	for _, host := range []string{"example.test", "127.0.0.1", "::1"} {
+		verifyTestCertificate(t, cert, ca, host)
+	}
+	// The CA key defaults to the CA certificate path with a .key extension
+	for _, key := range []string{"server.key", "ca/rootCA.key"} {
+		if stat, err := os.Stat(path(key)); err != nil || stat.Mode().Perm() != 0600 {
+			t.Errorf("%s: got %v (%v), want a private key readable by its owner only", key, stat, err)
+		}
+	}
+	if _, err := tls.LoadX509KeyPair(path("server.crt"), path("server.key")); err != nil {
+		t.Errorf("server key pair: %v", err)
+	}
+
+	// The existing CA signs the new certificate, so that it does not have to be trusted again
+	caPEM, _ := ioutil.ReadFile(path("ca/rootCA.pem"))
+	if code := genCert(args("other.test")); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	if newCAPEM, _ := ioutil.ReadFile(path("ca/rootCA.pem")); !bytes.Equal(caPEM, newCAPEM) {
+		t.Errorf("root CA replaced")
+	}
+	verifyTestCertificate(t, readTestCertificate(t, path("server.crt")), ca, "other.test")
+
+	if code := genCert(args(" , ")); code != 1 {
+		t.Errorf("no hosts: got exit code %d, want 1", code)
+	}
+	// A server certificate cannot sign other certificates
+	if code := genCert(append(args("example.test"), "-ca-cert", path("server.crt"), "-ca-key", path("server.key"))); code != 1 {
+		t.Errorf("CA which is not a CA: got exit code %d, want 1", code)
+	}
+	if code := genCert([]string{"-unknown"}); code != 2 {
+		t.Errorf("unknown flag: got exit code %d, want 2", code)
+	}
+}
+
+func TestGenCertConfigPaths(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.toml")
+	writeTestFiles(t, dir, map[string]string{"config.toml": `
+[minosse.tls]
+x509CertPath = "` + filepath.Join(dir, "tls/cert.pem") + `"
+x509KeyPath = "` + filepath.Join(dir, "tls/key.pem") + `"
+x509RootCAPath = "` + filepath.Join(dir, "tls/ca.pem") + `"
+`})
+
+	if code := genCert([]string{"-config", configPath}); code != 0 {
+		t.Fatalf("got exit code %d", code)
+	}
+	ca := readTestCertificate(t, filepath.Join(dir, "tls/ca.pem"))
+	cert := readTestCertificate(t, filepath.Join(dir, "tls/cert.pem"))
+	// Default hosts
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		verifyTestCertificate(t, cert, ca, host)
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, "tls/cert.pem"), filepath.Join(dir, "tls/key.pem")); err != nil {
+		t.Errorf("server key pair: %v", err)
+	}
+}
+
+func TestServerCertificateTemplate(t *testing.T) {
+	tests := []struct {
+		hosts       []string
+		dnsNames    int
+		ipAddresses int
+		commonName  string
+	}{
+		{[]string{"example.test"}, 1, 0, "example.test"},
+		{[]string{"127.0.0.1", "example.test", "*.example.test"}, 2, 1, "example.test"},
+		{[]string{"::1", "fe80::1"}, 0, 2, ""},
+		{[]string{" localhost ", ""}, 1, 0, "localhost"},
+		{[]string{"", " "}, 0, 0, ""},
+	}
+	for _, test := range tests {
+		template, err := serverCertificateTemplate(test.hosts, time.Hour)
+		if test.dnsNames == 0 && test.ipAddresses == 0 {
+			if err != errNoHosts {
+				t.Errorf("%q: got %v, want no hosts error", test.hosts, err)
+			}
+			continue
+		}
+		if err != nil || len(template.DNSNames) != test.dnsNames || len(template.IPAddresses) != test.ipAddresses || template.Subject.CommonName != test.commonName {
+			t.Errorf("%q: got DNS names %v, IP addresses %v, common name %q (%v)", test.hosts, template.DNSNames, template.IPAddresses, template.Subject.CommonName, err)
+		}
+	}
+}
+
+func TestAutoSelfSigned(t *testing.T) {
+	conf := newTestConfig(t)
+	resetCertificates(t)
+	setTestSites(t, conf, `
+[[site]]
+hosts = ["example.test", "*.example.test"]
+`)
+	applyDefaultConfigValues(conf)
+	addSelfSignedCertificate()
+
+	for _, host := range []string{"localhost", "127.0.0.1", "example.test", "www.example.test"} {
+		cert, err := getCertificate(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Self-signed: the certificate is its own root
+		verifyTestCertificate(t, cert.Leaf, cert.Leaf, host)
+	}
+	if cert := certificates[0].get(); time.Until(cert.Leaf.NotAfter) > SelfSignedCertificateValidity {
+		t.Errorf("got a certificate valid until %s", cert.Leaf.NotAfter)
+	}
+
+	// There are no files to reload it from
+	cert := certificates[0].get()
+	reloadCertificates(nil)
+	if certificates[0].get() != cert {
+		t.Errorf("self-signed certificate replaced by a reload")
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// reloadableCertificate An x509 key pair read from disk, which can be reloaded while serving
+type reloadableCertificate struct {
+	paths Certificate
+	mutex sync.RWMutex
+	cert  *tls.Certificate
+}
+
+var certificates []*reloadableCertificate
+
+// load Reads the key pair from disk. On failure the previously loaded certificate, if any, is kept in place
+func (c *reloadableCertificate) load() error {
+	cert, err := tls.LoadX509KeyPair(c.paths.X509CertPath, c.paths.X509KeyPath)
+	if err != nil {
+		return err
+	}
+	// The parsed leaf spares parsing it again on every handshake when matching server names
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return err
+	}
+	c.mutex.Lock()
+	c.cert = &cert
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *reloadableCertificate) get() *tls.Certificate {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert
+}
+
+// loadCertificates Loads the [minosse.tls] certificate, when specified, the additional ones and the ones of every
+// site. Any failure is fatal at startup
+func loadCertificates(conf *TLS) {
+	pairs := conf.Certificates
+	if conf.X509CertPath != "" {
+		pairs = append([]Certificate{{X509CertPath: conf.X509CertPath, X509KeyPath: conf.X509KeyPath}}, pairs...)
+	}
+	for _, pair := range pairs {
+		certificates = append(certificates, mustLoadCertificate(pair))
+	}
+	for _, vh := range virtualHosts {
+		if vh.Certificate.X509CertPath != "" {
+			vh.certificate = mustLoadCertificate(vh.Certificate)
+			certificates = append(certificates, vh.certificate)
+		}
+	}
+}
+
+// addSelfSignedCertificate Generates the in-memory self-signed certificate, used when nothing else matches
+func addSelfSignedCertificate() {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	for _, vh := range virtualHosts {
+		hosts = append(hosts, vh.Hosts...)
+	}
+	cert, err := selfSignedCertificate(hosts)
+	if err != nil {
+		logChannel.fatalError("Fatal error while generating self-signed certificate", err)
+	}
+	certificates = append(certificates, &reloadableCertificate{cert: cert})
+	logChannel.channel <- Log{level: WARNING, message: "Serving a self-signed certificate, meant for development only", data: []zap.Field{zap.Strings("hosts", hosts)}}
+}
+
+func mustLoadCertificate(pair Certificate) *reloadableCertificate {
+	c := &reloadableCertificate{paths: pair}
+	if err := c.load(); err != nil {
+		logChannel.fatalError("Fatal error while loading x509 keypair", err)
+	}
+	return c
+}
+
+// getCertificate Chooses the certificate for a TLS handshake through SNI. ACME hosts get the certificate obtained for
+// them, otherwise the one of the site serving the requested name comes first, then any certificate valid for it and
+// compatible with the client. The first certificate loaded, usually the [minosse.tls] one, is used when nothing matches
+func getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if acmeCertificate(hello) {
+		return acmeManager.GetCertificate(hello)
+	}
+	candidates := certificates
+	if vh := selectVirtualHost(hello.ServerName); vh.certificate != nil {
+		candidates = append([]*reloadableCertificate{vh.certificate}, certificates...)
+	}
+	if len(candidates) == 0 {
+		return nil, errNoCertificate
+	}
+	if hello.ServerName != "" {
+		for _, c := range candidates {
+			if cert := c.get(); hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return candidates[0].get(), nil
+}
+
+// reloadCertificates Reloads every certificate whose files are among changed, or all of them when changed is nil
+func reloadCertificates(changed map[string]bool) {
+	for _, c := range certificates {
+		// Generated certificates have no files to reload from
+		if c.paths.X509CertPath == "" || changed != nil && !changed[c.paths.X509CertPath] && !changed[c.paths.X509KeyPath] {
+			continue
+		}
+		if err := c.load(); err != nil {
+			logChannel.channel <- Log{level: ERROR, message: "Error while reloading x509 keypair, keeping the previous one", data: []zap.Field{zap.Error(err), zap.String("certificate", c.paths.X509CertPath)}}
+			continue
+		}
+		logChannel.channel <- Log{level: INFO, message: "Reloaded x509 keypair", data: []zap.Field{zap.String("certificate", c.paths.X509CertPath)}}
+	}
+}
+
+// watchCertificates Reloads certificates as soon as their files change. Directories are watched rather than files, so
+// that files replaced through renames or symlink swaps (as most ACME clients do) are noticed too. Bursts of events are
+// coalesced, since certificate and key are seldom written at the same time
+func watchCertificates() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logChannel.channel <- Log{level: WARNING, message: "Filesystem notifications unavailable, certificates are only reloaded on SIGHUP", data: []zap.Field{zap.Error(err)}}
+		return
+	}
+	defer watcher.Close()
+
+	watched := make(map[string]string)
+	for _, c := range certificates {
+		if c.paths.X509CertPath == "" {
+			continue
+		}
+		for _, path := range []string{c.paths.X509CertPath, c.paths.X509KeyPath} {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				continue
+			}
+			watched[abs] = path
+			if err := watcher.Add(filepath.Dir(abs)); err != nil {
+				logChannel.channel <- Log{level: WARNING, message: "Could not watch certificate directory, certificates are only reloaded on SIGHUP", data: []zap.Field{zap.Error(err)}}
+				return
+			}
+		}
+	}
+
+	changed := make(map[string]bool)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if path, ok := watched[event.Name]; ok {
+				changed[path] = true
+				debounce = time.After(CertificateReloadDelay)
+			}
+		case <-debounce:
+			reloadCertificates(changed)
+			changed = make(map[string]bool)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			logChannel.error("Error while watching certificates", err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate Writes a self-signed certificate for hosts, signed by key, to name.crt and name.key in dir
+func writeTestCertificate(t *testing.T, dir, name string, key crypto.Signer, hosts ...string) Certificate {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair := Certificate{X509CertPath: filepath.Join(dir, name+".crt"), X509KeyPath: filepath.Join(dir, name+".key")}
+	if err := ioutil.WriteFile(pair.X509CertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pair.X509KeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func newTestECDSAKey(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestRSAKey(t *testing.T) crypto.Signer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// resetCertificates Forgets the loaded certificates at the end of the test
+func resetCertificates(t *testing.T) {
+	previous := certificates
+	certificates = nil
+	t.Cleanup(func() { certificates = previous })
+}
+
+// loadedName Returns the common name of a loaded certificate
+func loadedName(cert *tls.Certificate) string {
+	if cert == nil || cert.Leaf == nil {
+		return ""
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestGetCertificate(t *testing.T) {
+	conf := newTestConfig(t)
+	resetCertificates(t)
+	dir := t.TempDir()
+	fallback := writeTestCertificate(t, dir, "fallback", newTestECDSAKey(t), "default.test")
+	conf.Minosse.TLS = TLS{X509CertPath: fallback.X509CertPath, X509KeyPath: fallback.X509KeyPath, Certificates: []Certificate{
+		writeTestCertificate(t, dir, "extra", newTestECDSAKey(t), "extra.test", "example.com"),
+		writeTestCertificate(t, dir, "rsa", newTestRSAKey(t), "example.com"),
+	}}
+	example := writeTestCertificate(t, dir, "example", newTestECDSAKey(t), "example.com", "*.example.com")
+	setTestSites(t, conf, `
+[[site]]
+hosts = ["example.com", "*.example.com"]
+[site.certificate]
+x509CertPath = "`+example.X509CertPath+`"
+x509KeyPath = "`+example.X509KeyPath+`"
+`)
+	applyDefaultConfigValues(conf)
+	loadCertificates(&conf.Minosse.TLS)
+
+	modern := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{tls.VersionTLS13, tls.VersionTLS12},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+	}
+	rsaOnly := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{tls.VersionTLS12},
+		SignatureSchemes:  []tls.SignatureScheme{tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+		CipherSuites:      []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519, tls.CurveP256},
+		SupportedPoints:   []uint8{0},
+	}
+
+	tests := []struct {
+		name       string
+		hello      *tls.ClientHelloInfo
+		serverName string
+		cert       string
+	}{
+		// The certificate of the site comes first, even when another one is valid for the same name
+		{"site certificate", modern, "example.com", "example"},
+		{"site certificate through a wildcard", modern, "www.example.com", "example"},
+		// Then any certificate valid for the name and supported by the client
+		{"additional certificate", modern, "extra.test", "extra"},
+		{"certificate supported by the client", rsaOnly, "example.com", "rsa"},
+		// Then the [minosse.tls] one
+		{"unknown name", modern, "unknown.test", "fallback"},
+		{"no server name", modern, "", "fallback"},
+		{"nothing supported by the client", rsaOnly, "extra.test", "fallback"},
+	}
+	for _, test := range tests {
+		hello := *test.hello
+		hello.ServerName = test.serverName
+		cert, err := getCertificate(&hello)
+		if err != nil || loadedName(cert) != test.cert {
+			t.Errorf("%s: got %s (%v), want %s", test.name, loadedName(cert), err, test.cert)
+		}
+	}
+}
+
+func TestReloadCertificates(t *testing.T) {
+	newTestConfig(t)
+	resetCertificates(t)
+	dir := t.TempDir()
+	first := writeTestCertificate(t, dir, "first", newTestECDSAKey(t), "example.com")
+	other := writeTestCertificate(t, dir, "other", newTestECDSAKey(t), "other.test")
+	loadCertificates(&TLS{X509CertPath: first.X509CertPath, X509KeyPath: first.X509KeyPath, Certificates: []Certificate{other}})
+	c := certificates[0]
+
+	// Only the certificates whose files changed are reloaded
+	renewed := writeTestCertificate(t, dir, "first", newTestECDSAKey(t), "example.com")
+	serial := c.get().Leaf.SerialNumber
+	otherCert := certificates[1].get()
+	reloadCertificates(map[string]bool{renewed.X509CertPath: true})
+	if c.get().Leaf.SerialNumber.Cmp(serial) == 0 {
+		t.Errorf("renewed certificate not reloaded")
+	}
+	if certificates[1].get() != otherCert {
+		t.Errorf("unchanged certificate reloaded")
+	}
+
+	// A failed reload keeps the previous certificate in place
+	current := c.get()
+	if err := ioutil.WriteFile(first.X509KeyPath, []byte("half written key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	testLogs.TakeAll()
+	reloadCertificates(nil)
+	if c.get() != current {
+		t.Errorf("certificate replaced by a failed reload")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for testLogs.FilterMessage("Error while reloading x509 keypair, keeping the previous one").Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("failed reload not logged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Key pairs which do not match are rejected as well
+	mismatched := writeTestCertificate(t, dir, "mismatched", newTestECDSAKey(t), "example.com")
+	c.paths.X509KeyPath = mismatched.X509KeyPath
+	reloadCertificates(nil)
+	if c.get() != current {
+		t.Errorf("certificate replaced by a key pair which does not match")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+)
+
+const (
+	// CLIENT_AUTH_NONE never asks for client certificates
+	CLIENT_AUTH_NONE string = "none"
+	// CLIENT_AUTH_REQUEST asks for a client certificate, without requiring nor verifying it during the handshake
+	CLIENT_AUTH_REQUEST string = "request"
+	// CLIENT_AUTH_REQUIRE requires a client certificate during the handshake, without verifying it
+	CLIENT_AUTH_REQUIRE string = "require"
+	// CLIENT_AUTH_VERIFY requires a client certificate signed by one of the client CAs during the handshake
+	CLIENT_AUTH_VERIFY string = "verify"
+)
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	CLIENT_AUTH_NONE:    tls.NoClientCert,
+	CLIENT_AUTH_REQUEST: tls.RequestClientCert,
+	CLIENT_AUTH_REQUIRE: tls.RequireAnyClientCert,
+	CLIENT_AUTH_VERIFY:  tls.RequireAndVerifyClientCert,
+}
+
+// clientCAs Certificate authorities client certificates are verified against, loaded from X509RootCAPath
+var clientCAs *x509.CertPool
+
+// clientCertRequired Reports whether any of the given URL paths is only served to clients with a verified certificate.
+// Paths are cleaned before being matched, so that "/docs/../internal/" or "//internal/" are protected as "/internal/"
+func (vh *virtualHost) clientCertRequired(urlPaths ...string) bool {
+	for _, urlPath := range urlPaths {
+		for _, prefix := range vh.Access.ClientCertPrefixes {
+			if pathHasPrefix(urlPath, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// verifiedClientCertificate Reports whether the client presented a certificate signed by one of the client CAs. The
+// handshake already verified it in "verify" mode, otherwise it is verified here
+func verifiedClientCertificate(req *http.Request) bool {
+	if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 || clientCAs == nil {
+		return false
+	}
+	if len(req.TLS.VerifiedChains) > 0 {
+		return true
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range req.TLS.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := req.TLS.PeerCertificates[0].Verify(x509.VerifyOptions{Roots: clientCAs, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	return err == nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCA Generates a certificate authority, along with its key
+func newTestCA(t *testing.T) (*x509.Certificate, tls.Certificate) {
+	t.Helper()
+	key := newTestECDSAKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: ca}
+}
+
+// issueTestClientCertificate Issues a client certificate signed by issuer
+func issueTestClientCertificate(t *testing.T, issuer tls.Certificate, name string) tls.Certificate {
+	t.Helper()
+	key := newTestECDSAKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer.Leaf, key.Public(), issuer.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// fetchTLS Sends a single request over TLS, presenting clientCerts, and returns its response
+func fetchTLS(t *testing.T, addr string, clientCerts []tls.Certificate, request string) testResponse {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, Certificates: clientCerts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	return readTestResponse(t, bufio.NewReader(conn), "GET")
+}
+
+func TestClientCertRequired(t *testing.T) {
+	vh := &virtualHost{Site: Site{Access: Access{ClientCertPrefixes: []string{"/admin/", "/api/private"}}}}
+	tests := []struct {
+		path     string
+		required bool
+	}{
+		{"/admin/", true},
+		{"/admin/users", true},
+		{"/admin", true},
+		{"/api/private/keys", true},
+		{"/api/private", true},
+		{"/docs/../admin/", true},
+		{"//admin/", true},
+		{"/./admin/users", true},
+		{"/adminfoo", false},
+		{"/api/privatekeys", false},
+		{"/", false},
+		{"/public/admin/", false},
+	}
+	for _, test := range tests {
+		if got := vh.clientCertRequired(test.path); got != test.required {
+			t.Errorf("%s: got %t, want %t", test.path, got, test.required)
+		}
+	}
+}
+
+func TestClientCertPrefixes(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Access.ClientCertPrefixes = []string{"/internal/"}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"internal/s.txt": "secret", "internalfoo/x.txt": "public", "docs/a.txt": "public"})
+	addr := startTestServer(t)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/internal/s.txt", 403},
+		{"/internal", 403},
+		{"/internal/missing.txt", 403},
+		{"/docs/../internal/s.txt", 403},
+		{"//internal/s.txt", 403},
+		{"/./internal/s.txt", 403},
+		{"/%69nternal/s.txt", 403},
+		{"/docs/%2e%2e/internal/s.txt", 403},
+		{"/internalfoo/x.txt", 200},
+		{"/docs/a.txt", 200},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, rawRequest("GET", test.path))
+		if res.StatusCode != test.status {
+			t.Errorf("GET %s: got status %d, want %d", test.path, res.StatusCode, test.status)
+		}
+		if res.StatusCode == 403 && res.body == "secret" {
+			t.Errorf("GET %s: protected content served", test.path)
+		}
+	}
+}
+
+func TestClientCertPrefixesTryFilesFallback(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Access.ClientCertPrefixes = []string{"/internal"}
+	conf.Minosse.TryFiles = TryFiles{Candidates: []string{"$uri", "/internal/s.txt"}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"internal/s.txt": "secret"})
+	addr := startTestServer(t)
+
+	if res := fetch(t, addr, rawRequest("GET", "/missing")); res.StatusCode != 403 {
+		t.Errorf("fallback to a protected file: got status %d, want 403", res.StatusCode)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	ca, caCert := newTestCA(t)
+	_, otherCACert := newTestCA(t)
+	trusted := []tls.Certificate{issueTestClientCertificate(t, caCert, "trusted")}
+	untrusted := []tls.Certificate{issueTestClientCertificate(t, otherCACert, "untrusted")}
+
+	previousCAs := clientCAs
+	clientCAs = x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	t.Cleanup(func() { clientCAs = previousCAs })
+
+	// "none" is raised to "request", since some paths need a client certificate
+	for _, mode := range []string{CLIENT_AUTH_NONE, CLIENT_AUTH_REQUEST, CLIENT_AUTH_VERIFY} {
+		t.Run(mode, func(t *testing.T) {
+			conf := newTestConfig(t)
+			dir := t.TempDir()
+			server := writeTestCertificate(t, dir, "server", newTestECDSAKey(t), "localhost")
+			conf.Minosse.TLS = TLS{Enabled: true, X509CertPath: server.X509CertPath, X509KeyPath: server.X509KeyPath, X509RootCAPath: "ca.pem", ClientAuth: mode}
+			conf.Minosse.Access.ClientCertPrefixes = []string{"admin/"}
+			writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"index.html": "public", "admin/index.html": "admin"})
+
+			pair, err := tls.LoadX509KeyPair(server.X509CertPath, server.X509KeyPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The client authentication mode is the one left once the default values are applied
+			addr := serveTestListener(t, tls.NewListener(listener, &tls.Config{GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+				return &tls.Config{Certificates: []tls.Certificate{pair}, ClientAuth: clientAuthTypes[config.Minosse.TLS.ClientAuth], ClientCAs: clientCAs}, nil
+			}}))
+
+			if res := fetchTLS(t, addr, trusted, rawRequest("GET", "/admin/")); res.StatusCode != 200 || res.body != "admin" {
+				t.Errorf("trusted certificate: got %d %q, want 200 admin", res.StatusCode, res.body)
+			}
+			if mode == CLIENT_AUTH_VERIFY {
+				// The handshake itself rejects anything else
+				return
+			}
+			if res := fetchTLS(t, addr, nil, rawRequest("GET", "/")); res.StatusCode != 200 || res.body != "public" {
+				t.Errorf("no certificate outside the prefixes: got %d %q, want 200 public", res.StatusCode, res.body)
+			}
+			if res := fetchTLS(t, addr, nil, rawRequest("GET", "/admin/")); res.StatusCode != 403 {
+				t.Errorf("no certificate: got %d, want 403", res.StatusCode)
+			}
+			if res := fetchTLS(t, addr, untrusted, rawRequest("GET", "/admin/")); res.StatusCode != 403 {
+				t.Errorf("certificate signed by another CA: got %d, want 403", res.StatusCode)
+			}
+		})
+	}
+}
+
+func TestClientAuthRaisedForPrefixes(t *testing.T) {
+	pair := writeTestCertificate(t, t.TempDir(), "server", newTestECDSAKey(t), "localhost")
+	tests := []struct {
+		name       string
+		clientAuth string
+		prefixes   []string
+		want       string
+	}{
+		{"no prefixes", "", nil, CLIENT_AUTH_NONE},
+		{"prefixes", "", []string{"/internal/"}, CLIENT_AUTH_REQUEST},
+		{"prefixes with none", CLIENT_AUTH_NONE, []string{"/internal/"}, CLIENT_AUTH_REQUEST},
+		{"prefixes with require", CLIENT_AUTH_REQUIRE, []string{"/internal/"}, CLIENT_AUTH_REQUIRE},
+		{"prefixes with verify", CLIENT_AUTH_VERIFY, []string{"/internal/"}, CLIENT_AUTH_VERIFY},
+	}
+	for _, test := range tests {
+		conf := newTestConfig(t)
+		conf.Minosse.TLS = TLS{Enabled: true, X509CertPath: pair.X509CertPath, X509KeyPath: pair.X509KeyPath, X509RootCAPath: "ca.pem", ClientAuth: test.clientAuth}
+		conf.Minosse.Access.ClientCertPrefixes = test.prefixes
+		applyDefaultConfigValues(conf)
+		if conf.Minosse.TLS.ClientAuth != test.want {
+			t.Errorf("%s: got client authentication mode %q, want %q", test.name, conf.Minosse.TLS.ClientAuth, test.want)
+		}
+	}
+
+	// Prefixes of a [[site]] count as well
+	conf := newTestConfig(t)
+	conf.Minosse.TLS = TLS{Enabled: true, X509CertPath: pair.X509CertPath, X509KeyPath: pair.X509KeyPath, X509RootCAPath: "ca.pem"}
+	setTestSites(t, conf, `
+[[site]]
+hosts = ["example.com"]
+[site.access]
+clientCertPrefixes = ["/internal/"]
+`)
+	applyDefaultConfigValues(conf)
+	if conf.Minosse.TLS.ClientAuth != CLIENT_AUTH_REQUEST {
+		t.Errorf("site prefixes: got client authentication mode %q, want %q", conf.Minosse.TLS.ClientAuth, CLIENT_AUTH_REQUEST)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder A reusable compressor for a single content encoding. Every worker owns one per configured encoding
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// newEncoders Instantiates one encoder for each configured content encoding
+func newEncoders(compression Compression) map[string]encoder {
+	encoders := make(map[string]encoder)
+	if !compression.Enabled {
+		return encoders
+	}
+
+	for _, encoding := range compression.Encodings {
+		switch encoding {
+		case GZIP:
+			encoders[GZIP], _ = gzip.NewWriterLevel(nil, compression.GzipLevel)
+		case BROTLI:
+			encoders[BROTLI] = brotli.NewWriterLevel(nil, compression.BrotliLevel)
+		case ZSTD:
+			// Workers already run concurrently, a single goroutine per encoder is enough
+			zstdEncoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(compression.ZstdLevel)), zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				logChannel.error("Error while creating zstd encoder", err)
+				continue
+			}
+			encoders[ZSTD] = zstdEncoder
+		}
+	}
+	return encoders
+}
+
+// sidecarExtensions File extensions of the precompressed sidecars, for each content encoding
+var sidecarExtensions = map[string]string{
+	BROTLI: ".br",
+	ZSTD:   ".zst",
+	GZIP:   ".gz",
+}
+
+// openSidecar Looks next to pathFile for a precompressed sidecar (app.js.br, app.js.gz, ...) in an encoding accepted by
+// the client, following the server-side preference order. Sidecars older than the original file are considered stale
+// and ignored. Returns a nil file when no suitable sidecar exists
+func openSidecar(pathFile string, original os.FileInfo, acceptEncoding string, encodings []string) (*os.File, os.FileInfo, string) {
+	var available []string
+	for _, encoding := range encodings {
+		ext, ok := sidecarExtensions[encoding]
+		if !ok {
+			continue
+		}
+		if stat, err := os.Stat(pathFile + ext); err == nil && stat.Mode().IsRegular() && !stat.ModTime().Before(original.ModTime()) {
+			available = append(available, encoding)
+		}
+	}
+	if len(available) == 0 {
+		return nil, nil, ""
+	}
+
+	encoding := negotiateEncoding(acceptEncoding, available)
+	if encoding == IDENTITY || encoding == "" {
+		return nil, nil, ""
+	}
+	sidecar, err := os.Open(pathFile + sidecarExtensions[encoding])
+	if err != nil {
+		return nil, nil, ""
+	}
+	stat, err := sidecar.Stat()
+	if err != nil {
+		sidecar.Close()
+		return nil, nil, ""
+	}
+	return sidecar, stat, encoding
+}
+
+// compressionFilter Reports whether the file is worth compressing, according to its size and name
+func (vh *virtualHost) compressionFilter(f os.FileInfo) bool {
+	return f.Size() > vh.Compression.Threshold && !vh.excludePattern.MatchString(f.Name())
+}
+
+// streamCompression Reports whether the file is too big to be compressed in memory, and should be streamed instead
+func (vh *virtualHost) streamCompression(f os.FileInfo) bool {
+	return vh.Compression.Stream && f.Size() > vh.Compression.MaxBufferedSize
+}
+
+// onTheFlyCompression Reports whether the file can be compressed while serving it: files over MaxBufferedSize are never
+// compressed in memory, so they are only compressed when streaming is enabled
+func (vh *virtualHost) onTheFlyCompression(f os.FileInfo) bool {
+	return vh.Compression.Stream || f.Size() <= vh.Compression.MaxBufferedSize
+}
+
+// negotiateEncoding Chooses the content encoding for a response out of the Accept-Encoding request header (RFC 7231
+// section 5.3.4). The coding with the highest q-value wins, ties are broken by the server-side preference order.
+// Identity competes too: it is returned when nothing is better, and an empty string when even identity is refused
+func negotiateEncoding(acceptEncoding string, preferences []string) string {
+	if acceptEncoding == "" {
+		return IDENTITY
+	}
+
+	accepted := parseAcceptEncoding(acceptEncoding)
+	wildcard, hasWildcard := accepted["*"]
+	identityQ := identityQuality(accepted)
+
+	best, bestQ := "", 0.0
+	for _, encoding := range preferences {
+		q, ok := accepted[encoding]
+		if encoding == IDENTITY {
+			q, ok = identityQ, true
+		}
+		if !ok && encoding == GZIP {
+			q, ok = accepted["x-gzip"]
+		}
+		if !ok && hasWildcard {
+			q, ok = wildcard, true
+		}
+		if ok && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	// Identity is not a preference of the server: it only wins over the other codings with a higher q-value
+	if identityQ > bestQ {
+		return IDENTITY
+	}
+	return best
+}
+
+// acceptsIdentity Reports whether the Accept-Encoding request header allows a response without content coding
+func acceptsIdentity(acceptEncoding string) bool {
+	return acceptEncoding == "" || identityQuality(parseAcceptEncoding(acceptEncoding)) > 0
+}
+
+// identityQuality Returns the q-value of the identity encoding, listed by itself or through the wildcard. Unless
+// listed, identity is always acceptable (RFC 7231 section 5.3.4)
+func identityQuality(accepted map[string]float64) float64 {
+	if q, ok := accepted[IDENTITY]; ok {
+		return q
+	}
+	if q, ok := accepted["*"]; ok {
+		return q
+	}
+	return 1
+}
+
+// parseAcceptEncoding Maps every coding listed in an Accept-Encoding header to its q-value
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, item := range strings.Split(header, ",") {
+		params := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if len(param) > 2 && (param[0] == 'q' || param[0] == 'Q') && param[1] == '=' {
+				if value, err := strconv.ParseFloat(param[2:], 64); err == nil && value >= 0 && value <= 1 {
+					q = value
+				} else {
+					q = 0
+				}
+			}
+		}
+		accepted[coding] = q
+	}
+	return accepted
+}
+
+// serveCompressedStream Compresses f while sending it, without knowing the final Content-Length in advance
+func serveCompressedStream(w *bufio.Writer, req *http.Request, f io.Reader, headers map[string]string, enc encoder, keepAlive bool) (Response, bool) {
+	response, stream, keepAlive, err := startStream(w, req, headers, keepAlive)
+	if err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+	if req.Method == HTTP_HEAD_METHOD {
+		return response, keepAlive
+	}
+
+	enc.Reset(stream)
+	if _, err := io.Copy(enc, f); err != nil {
+		logChannel.error("Error during compression", err)
+		return response, false
+	}
+	if err := enc.Close(); err != nil {
+		logChannel.error("Error while closing compression", err)
+		return response, false
+	}
+	if err := stream.Close(); err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+	return response, keepAlive
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	preferences := []string{BROTLI, ZSTD, GZIP}
+
+	tests := []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"", IDENTITY},
+		{"gzip", GZIP},
+		{"GZIP", GZIP},
+		{"x-gzip", GZIP},
+		{"deflate", IDENTITY},
+		// Ties are broken by the server-side preference order
+		{"gzip, br, zstd", BROTLI},
+		{"gzip, zstd", ZSTD},
+		{"*", BROTLI},
+		// q-values
+		{"br;q=0.5, gzip", GZIP},
+		{"br;q=0.5, zstd;q=0.8, gzip;q=0.2, identity;q=0.1", ZSTD},
+		{"br; q=0.5, gzip; Q=0.9, identity;q=0.5", GZIP},
+		{"gzip;q=1.0, br;q=1", BROTLI},
+		{"br;q=0, gzip", GZIP},
+		{"gzip;q=0", IDENTITY},
+		{"x-gzip;q=0.3, zstd;q=0.2, identity;q=0", GZIP},
+		// Invalid q-values make the coding unacceptable
+		{"br;q=2, gzip", GZIP},
+		{"br;q=abc, gzip;q=0.1, identity;q=0", GZIP},
+		// Wildcards only apply to the codings which are not listed, identity included
+		{"*;q=0.5, br;q=0.1", ZSTD},
+		{"br;q=0, *", ZSTD},
+		{"*;q=0, gzip", GZIP},
+		{"*;q=0.5, identity", IDENTITY},
+		{" , gzip ,", GZIP},
+		// Unless listed, identity is acceptable with a q-value of 1
+		{"gzip;q=0.5", IDENTITY},
+		{"identity, gzip;q=0.5", IDENTITY},
+		{"identity;q=0.5, gzip;q=0.6", GZIP},
+		{"identity;q=0, gzip", GZIP},
+		{"*;q=0, identity", IDENTITY},
+		// Nothing acceptable
+		{"identity;q=0", ""},
+		{"*;q=0", ""},
+		{"deflate, identity;q=0", ""},
+	}
+	for _, test := range tests {
+		if encoding := negotiateEncoding(test.acceptEncoding, preferences); encoding != test.encoding {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", test.acceptEncoding, encoding, test.encoding)
+		}
+	}
+
+	if encoding := negotiateEncoding("br, gzip", []string{GZIP, BROTLI}); encoding != GZIP {
+		t.Errorf("negotiateEncoding with gzip preferred = %q, want gzip", encoding)
+	}
+	if encoding := negotiateEncoding("br", []string{GZIP}); encoding != IDENTITY {
+		t.Errorf("negotiateEncoding of a disabled coding = %q, want identity", encoding)
+	}
+	if encoding := negotiateEncoding("gzip", []string{IDENTITY, GZIP}); encoding != IDENTITY {
+		t.Errorf("negotiateEncoding with identity preferred = %q, want identity", encoding)
+	}
+}
+
+func TestNotAcceptable(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Compression.Enabled = true
+	content := strings.Repeat("compressible content ", 500)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"large.txt": content, "small.txt": "small", "image.png": content})
+	addr := startTestServer(t)
+
+	tests := []struct {
+		target         string
+		acceptEncoding string
+		status         int
+		encoding       string
+	}{
+		{"/large.txt", "identity;q=0", 406, ""},
+		{"/large.txt", "*;q=0", 406, ""},
+		{"/large.txt", "identity;q=0, gzip", 200, GZIP},
+		{"/large.txt", "*;q=0, identity", 200, IDENTITY},
+		// Files which are never compressed can only be refused
+		{"/small.txt", "identity;q=0, gzip", 406, ""},
+		{"/image.png", "*;q=0", 406, ""},
+		{"/image.png", "gzip;q=0.5", 200, IDENTITY},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, rawRequest("GET", test.target, "Accept-Encoding: "+test.acceptEncoding))
+		if res.StatusCode != test.status || res.Header.Get("Content-Encoding") != test.encoding {
+			t.Errorf("%s with %q: got %d encoded as %q, want %d encoded as %q", test.target, test.acceptEncoding, res.StatusCode, res.Header.Get("Content-Encoding"), test.status, test.encoding)
+		}
+		if test.status == 406 && (res.body != "406 Not Acceptable" || res.Header.Get("Vary") != "Accept-Encoding") {
+			t.Errorf("%s with %q: got body %q and Vary %q", test.target, test.acceptEncoding, res.body, res.Header.Get("Vary"))
+		}
+	}
+}
+
+func TestCompressionEncodings(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Compression.Enabled = true
+	content := strings.Repeat("compressible content ", 500)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"large.txt": content, "small.txt": "small", "image.png": content})
+	addr := startTestServer(t)
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		BROTLI: func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		ZSTD:   func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+		GZIP:   func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	}
+	for encoding, decoder := range decoders {
+		res := fetch(t, addr, rawRequest("GET", "/large.txt", "Accept-Encoding: "+encoding))
+		if got := res.Header.Get("Content-Encoding"); got != encoding {
+			t.Errorf("Accept-Encoding %s: got Content-Encoding %q", encoding, got)
+			continue
+		}
+		if res.Header.Get("Vary") != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %s: got Vary %q, want Accept-Encoding", encoding, res.Header.Get("Vary"))
+		}
+		reader, err := decoder(strings.NewReader(res.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body := readString(t, reader); body != content {
+			t.Errorf("Accept-Encoding %s: got %d bytes after decompression, want %d", encoding, len(body), len(content))
+		}
+	}
+
+	tests := []struct {
+		path string
+		vary string
+	}{
+		// Below the threshold and excluded files are never compressed
+		{"/small.txt", ""},
+		{"/image.png", ""},
+		{"/large.txt", "Accept-Encoding"},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, rawRequest("GET", test.path, "Accept-Encoding: deflate"))
+		if res.Header.Get("Content-Encoding") != IDENTITY || res.Header.Get("Vary") != test.vary {
+			t.Errorf("%s: got Content-Encoding %q and Vary %q, want identity and %q", test.path, res.Header.Get("Content-Encoding"), res.Header.Get("Vary"), test.vary)
+		}
+	}
+}
+
+func TestDeprecatedGzipConfig(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Gzip = GZip{Enabled: true, Level: 9, Threshold: 10, Exclude: "txt$"}
+	applyDefaultConfigValues(conf)
+
+	compression := conf.Minosse.Compression
+	if !compression.Enabled || len(compression.Encodings) != 1 || compression.Encodings[0] != GZIP {
+		t.Fatalf("got %+v, want gzip only", compression)
+	}
+	if compression.GzipLevel != 9 || compression.Threshold != 10 || compression.Exclude != "txt$" {
+		t.Errorf("gzip settings not carried over: %+v", compression)
+	}
+}
+
+// readString Reads r until EOF
+func readString(t *testing.T, r io.Reader) string {
+	t.Helper()
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestCompressionStream(t *testing.T) {
+	small, large := strings.Repeat("small ", 500), strings.Repeat("large ", 2000)
+
+	tests := []struct {
+		stream   bool
+		path     string
+		content  string
+		encoding string
+		chunked  bool
+	}{
+		{false, "/small.txt", small, GZIP, false},
+		// Too big to be compressed in memory, and streaming is disabled
+		{false, "/large.txt", large, IDENTITY, false},
+		{true, "/small.txt", small, GZIP, false},
+		{true, "/large.txt", large, GZIP, true},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("stream=%t %s", test.stream, test.path), func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.Minosse.Compression = Compression{Enabled: true, Encodings: []string{GZIP}, Stream: test.stream, MaxBufferedSize: 4096}
+			writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"small.txt": small, "large.txt": large})
+			addr := startTestServer(t)
+
+			res := fetch(t, addr, rawRequest("GET", test.path, "Accept-Encoding: gzip"))
+			encoding := res.Header.Get("Content-Encoding")
+			if encoding == "" {
+				encoding = IDENTITY
+			}
+			if encoding != test.encoding {
+				t.Errorf("got Content-Encoding %q, want %s", encoding, test.encoding)
+				return
+			}
+			if chunked := len(res.TransferEncoding) > 0; chunked != test.chunked {
+				t.Errorf("got chunked %t, want %t", chunked, test.chunked)
+			}
+			if !test.chunked && res.ContentLength != int64(len(res.body)) {
+				t.Errorf("Content-Length %d, but the body is %d bytes long", res.ContentLength, len(res.body))
+			}
+			body := res.body
+			if encoding == GZIP {
+				body = gunzip(t, body)
+			}
+			if body != test.content {
+				t.Errorf("got %d bytes after decompression, want %d", len(body), len(test.content))
+			}
+		})
+	}
+}
+
+func TestCompressionStreamConnection(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Compression = Compression{Enabled: true, Encodings: []string{GZIP}, Stream: true, MaxBufferedSize: 4096}
+	large := strings.Repeat("large ", 2000)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"large.txt": large, "small.txt": "small"})
+	addr := startTestServer(t)
+
+	t.Run("keep-alive after a chunked body", func(t *testing.T) {
+		responses := roundTrip(t, addr, rawRequest("GET", "/large.txt", "Accept-Encoding: gzip"), rawRequest("GET", "/small.txt"))
+		if gunzip(t, responses[0].body) != large || responses[0].Close {
+			t.Errorf("streamed response corrupted or closing the connection")
+		}
+		if responses[1].body != "small" {
+			t.Errorf("got %q after the streamed response, want small", responses[1].body)
+		}
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("HEAD", "/large.txt", "Accept-Encoding: gzip"))
+		if res.Header.Get("Content-Encoding") != "gzip" || len(res.TransferEncoding) == 0 || res.body != "" {
+			t.Errorf("got Content-Encoding %q, Transfer-Encoding %v and body %q, want a chunked gzip header only", res.Header.Get("Content-Encoding"), res.TransferEncoding, res.body)
+		}
+	})
+
+	t.Run("HTTP/1.0 body delimited by close", func(t *testing.T) {
+		conn := dialTestServer(t, addr)
+		io.WriteString(conn, "GET /large.txt HTTP/1.0\r\nAccept-Encoding: gzip\r\nConnection: keep-alive\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		res := readTestResponse(t, reader, "GET")
+		if len(res.TransferEncoding) > 0 || res.ContentLength != -1 || !res.Close {
+			t.Errorf("got Transfer-Encoding %v, Content-Length %d and close %t, want neither and close", res.TransferEncoding, res.ContentLength, res.Close)
+		}
+		if gunzip(t, res.body) != large {
+			t.Errorf("streamed body corrupted")
+		}
+	})
+}
+
+func TestPrecompressedSidecars(t *testing.T) {
+	content := strings.Repeat("original content ", 200)
+	tests := []struct {
+		name           string
+		precompressed  bool
+		stale          string
+		acceptEncoding string
+		encoding       string
+		body           string
+	}{
+		{"preference order", true, "", "gzip, br", BROTLI, "brotli sidecar"},
+		{"q-values", true, "", "gzip, br;q=0.5", GZIP, "gzip sidecar"},
+		{"no sidecar accepted", true, "", "deflate", IDENTITY, content},
+		{"stale sidecar", true, "app.js.br", "br, gzip", GZIP, "gzip sidecar"},
+		{"disabled", false, "", "br", BROTLI, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.Minosse.Compression = Compression{Enabled: true, Encodings: []string{BROTLI, GZIP}, Precompressed: test.precompressed}
+			writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"app.js": content, "app.js.br": "brotli sidecar", "app.js.gz": "gzip sidecar"})
+			// Sidecars are only fresh when they are not older than the original file
+			modTimes := map[string]time.Time{"app.js": time.Now().Add(-time.Hour)}
+			if test.stale != "" {
+				modTimes[test.stale] = time.Now().Add(-2 * time.Hour)
+			}
+			for name, modTime := range modTimes {
+				if err := os.Chtimes(filepath.Join(conf.Minosse.WebRoot, name), modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			addr := startTestServer(t)
+
+			res := fetch(t, addr, rawRequest("GET", "/app.js", "Accept-Encoding: "+test.acceptEncoding))
+			if res.StatusCode != 200 || res.Header.Get("Content-Encoding") != test.encoding {
+				t.Fatalf("got %d with Content-Encoding %q, want 200 with %q", res.StatusCode, res.Header.Get("Content-Encoding"), test.encoding)
+			}
+			if test.body == "" {
+				// Compressed on the fly
+				if body := readString(t, brotli.NewReader(strings.NewReader(res.body))); body != content {
+					t.Errorf("got %d bytes after decompression, want %d", len(body), len(content))
+				}
+				return
+			}
+			if res.body != test.body || res.ContentLength != int64(len(test.body)) {
+				t.Errorf("got %q with Content-Length %d, want %q", res.body, res.ContentLength, test.body)
+			}
+			if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/javascript") && !strings.HasPrefix(res.Header.Get("Content-Type"), "application/javascript") {
+				t.Errorf("got Content-Type %q, want the one of the original file", res.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
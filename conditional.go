@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fileDigest The content digest of a file, along with the state of the file it was computed from
+type fileDigest struct {
+	modTime time.Time
+	size    int64
+	opaque  string
+}
+
+// digestCache Content digests of the files served with hashed ETags, so that each version of a file is only read once
+// instead of on every request, 304 responses included
+var digestCache *lruCache
+
+// entityTag Generates the ETag of the representation of f, opened from pathFile, served with the given content
+// encoding. The tag is derived from size and modification time, or from a sha256 digest of the content when configured
+// so. An empty string is returned when ETags are disabled
+func entityTag(f io.ReadSeeker, pathFile string, stat os.FileInfo, encoding string, conf ETag) (string, error) {
+	if !conf.Enabled {
+		return "", nil
+	}
+
+	var opaque string
+	if conf.Hash {
+		var err error
+		if opaque, err = contentDigest(f, pathFile, stat); err != nil {
+			return "", err
+		}
+	} else {
+		opaque = strconv.FormatInt(stat.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(stat.Size(), 16)
+	}
+	// Each content encoding is a different representation, hence it needs a different tag
+	if encoding != "" && encoding != IDENTITY {
+		opaque += "-" + encoding
+	}
+
+	if conf.Weak {
+		return "W/\"" + opaque + "\"", nil
+	}
+	return "\"" + opaque + "\"", nil
+}
+
+// contentDigest Returns the (truncated) sha256 digest of f, going through the digest cache. Cached digests computed
+// from a different version of the file, according to its size and modification time, are recomputed
+func contentDigest(f io.ReadSeeker, pathFile string, stat os.FileInfo) (string, error) {
+	if digestCache != nil {
+		if value, ok := digestCache.get(pathFile); ok {
+			digest := value.(*fileDigest)
+			if digest.modTime.Equal(stat.ModTime()) && digest.size == stat.Size() {
+				digestCache.hit()
+				return digest.opaque, nil
+			}
+		}
+		digestCache.miss()
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	opaque := hex.EncodeToString(hash.Sum(nil)[:16])
+	if digestCache != nil {
+		digestCache.add(pathFile, &fileDigest{modTime: stat.ModTime(), size: stat.Size(), opaque: opaque}, int64(len(pathFile)+len(opaque)))
+	}
+	return opaque, nil
+}
+
+// checkPreconditions Evaluates the RFC 7232 conditional headers of req against the selected representation, in the
+// order mandated by section 6. Returns 0 when the request should be served normally, otherwise the status code
+// (304 or 412) to answer with
+func checkPreconditions(req *http.Request, etag string, modTime time.Time) int {
+	modTime = modTime.Truncate(time.Second)
+
+	if ifMatch := req.Header.Get(HEADER_IF_MATCH); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ifUnmodifiedSince := req.Header.Get(HEADER_IF_UNMODIFIED_SINCE); ifUnmodifiedSince != "" {
+		if t, err := http.ParseTime(ifUnmodifiedSince); err == nil && modTime.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	safeMethod := req.Method == HTTP_GET_METHOD || req.Method == HTTP_HEAD_METHOD
+	if ifNoneMatch := req.Header.Get(HEADER_IF_NONE_MATCH); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if safeMethod {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if ifModifiedSince := req.Header.Get(HEADER_IF_MODIFIED_SINCE); ifModifiedSince != "" && safeMethod {
+		if t, err := http.ParseTime(ifModifiedSince); err == nil && !modTime.After(t) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// etagListMatches Reports whether the header value (either "*" or a comma separated list of entity tags) matches etag,
+// using the strong or the weak comparison function
+func etagListMatches(header, etag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+
+	for header != "" {
+		header = strings.TrimLeft(header, " \t,")
+		if header == "" {
+			break
+		}
+		candidate, remain := scanETag(header)
+		if candidate == "" {
+			// Malformed list: skip to the next element
+			if i := strings.IndexByte(header, ','); i >= 0 {
+				header = header[i+1:]
+				continue
+			}
+			break
+		}
+		if strong && etagStrongMatch(candidate, etag) || !strong && etagWeakMatch(candidate, etag) {
+			return true
+		}
+		header = remain
+	}
+	return false
+}
+
+// scanETag Extracts the first entity tag of s, returning it along with the rest of the string
+func scanETag(s string) (etag, remain string) {
+	start := 0
+	if strings.HasPrefix(s, "W/") {
+		start = 2
+	}
+	if len(s)-start < 2 || s[start] != '"' {
+		return "", ""
+	}
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return s[:i+1], s[i+1:]
+		// Characters allowed in entity tags, see RFC 7232 section 2.3
+		case c == 0x21 || c >= 0x23 && c <= 0x7E || c >= 0x80:
+		default:
+			return "", ""
+		}
+	}
+	return "", ""
+}
+
+func etagStrongMatch(a, b string) bool {
+	return a == b && a != "" && !strings.HasPrefix(a, "W/")
+}
+
+func etagWeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestETagListMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		strong bool
+		match  bool
+	}{
+		{`*`, `"a"`, true, true},
+		{` * `, `"a"`, false, true},
+		{`"a"`, `"a"`, true, true},
+		{`"a"`, `"b"`, true, false},
+		{`"b", "a"`, `"a"`, true, true},
+		{`"b","a"`, `"a"`, false, true},
+		// Weak tags never match with the strong comparison function
+		{`W/"a"`, `"a"`, true, false},
+		{`"a"`, `W/"a"`, true, false},
+		{`W/"a"`, `W/"a"`, true, false},
+		{`W/"a"`, `"a"`, false, true},
+		{`"a"`, `W/"a"`, false, true},
+		{`W/"a"`, `W/"a"`, false, true},
+		// Malformed elements are skipped
+		{`a, "a"`, `"a"`, true, true},
+		{`"a`, `"a"`, true, false},
+		{`"a"`, ``, false, false},
+		{``, `"a"`, false, false},
+	}
+	for _, test := range tests {
+		if match := etagListMatches(test.header, test.etag, test.strong); match != test.match {
+			t.Errorf("etagListMatches(%q, %q, strong %t) = %t, want %t", test.header, test.etag, test.strong, match, test.match)
+		}
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"v1"`
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC)
+	before, at, after := modTime.Add(-time.Hour).Format(http.TimeFormat), modTime.Format(http.TimeFormat), modTime.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"no precondition", "GET", nil, 0},
+		{"If-None-Match matching", "GET", map[string]string{"If-None-Match": etag}, 304},
+		{"If-None-Match matching on HEAD", "HEAD", map[string]string{"If-None-Match": etag}, 304},
+		{"If-None-Match weakly matching", "GET", map[string]string{"If-None-Match": `W/"v1"`}, 304},
+		{"If-None-Match not matching", "GET", map[string]string{"If-None-Match": `"v0"`}, 0},
+		{"If-None-Match star", "GET", map[string]string{"If-None-Match": "*"}, 304},
+		{"If-None-Match matching on an unsafe method", "POST", map[string]string{"If-None-Match": etag}, 412},
+		{"If-Modified-Since at modification time", "GET", map[string]string{"If-Modified-Since": at}, 304},
+		{"If-Modified-Since after modification", "GET", map[string]string{"If-Modified-Since": after}, 304},
+		{"If-Modified-Since before modification", "GET", map[string]string{"If-Modified-Since": before}, 0},
+		{"If-Modified-Since invalid", "GET", map[string]string{"If-Modified-Since": "yesterday"}, 0},
+		{"If-Modified-Since on an unsafe method", "POST", map[string]string{"If-Modified-Since": after}, 0},
+		// Section 6, step 3: If-None-Match takes precedence over If-Modified-Since
+		{"If-None-Match not matching beats If-Modified-Since", "GET", map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": after}, 0},
+		{"If-Match matching", "GET", map[string]string{"If-Match": etag}, 0},
+		{"If-Match not matching", "GET", map[string]string{"If-Match": `"v0"`}, 412},
+		{"If-Match star", "GET", map[string]string{"If-Match": "*"}, 0},
+		{"If-Match weak", "GET", map[string]string{"If-Match": `W/"v1"`}, 412},
+		{"If-Unmodified-Since after modification", "GET", map[string]string{"If-Unmodified-Since": after}, 0},
+		{"If-Unmodified-Since before modification", "GET", map[string]string{"If-Unmodified-Since": before}, 412},
+		{"If-Unmodified-Since invalid", "GET", map[string]string{"If-Unmodified-Since": "yesterday"}, 0},
+		// Section 6, step 2: If-Unmodified-Since is only evaluated without If-Match
+		{"If-Match beats If-Unmodified-Since", "GET", map[string]string{"If-Match": etag, "If-Unmodified-Since": before}, 0},
+		// Section 6: If-Match is evaluated before If-None-Match
+		{"If-Match not matching before If-None-Match", "GET", map[string]string{"If-Match": `"v0"`, "If-None-Match": etag}, 412},
+		{"If-Match and If-None-Match matching", "GET", map[string]string{"If-Match": etag, "If-None-Match": etag}, 304},
+	}
+	for _, test := range tests {
+		req := &http.Request{Method: test.method, Header: make(http.Header)}
+		for name, value := range test.headers {
+			req.Header.Set(name, value)
+		}
+		if status := checkPreconditions(req, etag, modTime); status != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, status, test.status)
+		}
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.ETag.Enabled = true
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "content"})
+	addr := startTestServer(t)
+
+	res := fetch(t, addr, rawRequest("GET", "/a.txt"))
+	etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified")
+	if !strings.HasPrefix(etag, `"`) || lastModified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q", etag, lastModified)
+	}
+
+	res = fetch(t, addr, rawRequest("GET", "/a.txt", "If-None-Match: "+etag))
+	if res.StatusCode != 304 || res.body != "" || res.Header.Get("ETag") != etag {
+		t.Errorf("If-None-Match: got status %d, body %q and ETag %q, want 304 without body", res.StatusCode, res.body, res.Header.Get("ETag"))
+	}
+	if res = fetch(t, addr, rawRequest("GET", "/a.txt", "If-Modified-Since: "+lastModified)); res.StatusCode != 304 {
+		t.Errorf("If-Modified-Since: got status %d, want 304", res.StatusCode)
+	}
+	if res = fetch(t, addr, rawRequest("GET", "/a.txt", `If-Match: "other"`)); res.StatusCode != 412 {
+		t.Errorf("If-Match: got status %d, want 412", res.StatusCode)
+	}
+
+	// A new version of the file gets a new tag
+	modTime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(conf.Minosse.WebRoot, "a.txt"), modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if res = fetch(t, addr, rawRequest("GET", "/a.txt", "If-None-Match: "+etag)); res.StatusCode != 200 || res.Header.Get("ETag") == etag {
+		t.Errorf("modified file: got status %d and ETag %q, want 200 and a new ETag", res.StatusCode, res.Header.Get("ETag"))
+	}
+}
+
+func TestETagOptions(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.ETag.Enabled = true
+	conf.Minosse.ETag.Weak = true
+	conf.Minosse.ETag.Hash = true
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "content", "b.txt": "content"})
+	addr := startTestServer(t)
+
+	a, b := fetch(t, addr, rawRequest("GET", "/a.txt")), fetch(t, addr, rawRequest("GET", "/b.txt"))
+	if etag := a.Header.Get("ETag"); !strings.HasPrefix(etag, `W/"`) {
+		t.Errorf("got ETag %q, want a weak one", etag)
+	}
+	// Hashed tags only depend on the content
+	if a.Header.Get("ETag") != b.Header.Get("ETag") {
+		t.Errorf("same content with different ETags: %q and %q", a.Header.Get("ETag"), b.Header.Get("ETag"))
+	}
+	if res := fetch(t, addr, rawRequest("GET", "/a.txt", "If-None-Match: "+a.Header.Get("ETag"))); res.StatusCode != 304 {
+		t.Errorf("weak ETag in If-None-Match: got status %d, want 304", res.StatusCode)
+	}
+}
+
+func TestETagPerEncoding(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.ETag.Enabled = true
+	conf.Minosse.Compression = Compression{Enabled: true, Encodings: []string{GZIP}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": strings.Repeat("content ", 1000)})
+	addr := startTestServer(t)
+
+	identity, gzipped := fetch(t, addr, rawRequest("GET", "/a.txt")), fetch(t, addr, rawRequest("GET", "/a.txt", "Accept-Encoding: gzip"))
+	if gzipped.Header.Get("Content-Encoding") != "gzip" || identity.Header.Get("ETag") == gzipped.Header.Get("ETag") {
+		t.Errorf("got ETags %q and %q for the identity and gzip representations", identity.Header.Get("ETag"), gzipped.Header.Get("ETag"))
+	}
+}
+
+func TestHashedETagDigestCache(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.ETag = ETag{Enabled: true, Hash: true}
+	path := filepath.Join(conf.Minosse.WebRoot, "a.txt")
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "first version"})
+	addr := startTestServer(t)
+	digestCache = newLRUCache(DigestCacheMaxBytes, DigestCacheMaxEntries)
+	t.Cleanup(func() { digestCache = nil })
+
+	etag := fetch(t, addr, rawRequest("GET", "/a.txt")).Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag in response")
+	}
+
+	// The digest computed by the first request is reused, 304 responses included
+	if res := fetch(t, addr, rawRequest("GET", "/a.txt", "If-None-Match: "+etag)); res.StatusCode != 304 {
+		t.Errorf("got status %d, want 304", res.StatusCode)
+	}
+	if hits, misses := atomic.LoadUint64(&digestCache.hits), atomic.LoadUint64(&digestCache.misses); hits != 1 || misses != 1 {
+		t.Errorf("got %d digest cache hits and %d misses, want 1 and 1", hits, misses)
+	}
+
+	// A new version of the file gets a new digest
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "second, longer, version"})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if res := fetch(t, addr, rawRequest("GET", "/a.txt", "If-None-Match: "+etag)); res.StatusCode != 200 || res.Header.Get("ETag") == etag {
+		t.Errorf("modified file: got status %d and ETag %s, want 200 and a new ETag", res.StatusCode, res.Header.Get("ETag"))
+	}
+}
@@ -1,29 +1,164 @@
 package main
 
+import "github.com/pelletier/go-toml"
+
 // Config Central configuration structure (minosse, zap logger, etc...)
 type Config struct {
 	Minosse Minosse
-	Zap     Zap
+	// Sites virtual hosts, each one declared by a [[site]] table
+	Sites []Site `toml:"site"`
+	Zap   Zap
+	// siteTables raw [[site]] tables, needed to tell which settings a site overrides and which ones it inherits
+	siteTables []*toml.Tree
 }
 
-// Minosse Main minosse configuration structure
+// Minosse Main minosse configuration structure. Its site settings describe the default site, and act as defaults for
+// every [[site]]
 type Minosse struct {
-	Server           string
-	Port             int
-	WebRoot          string
-	Log              LogLevel
-	Connections      Connections
-	TLS              TLS
-	Gzip             GZip
+	Server      string
+	Port        int
+	Log         LogLevel
+	Connections Connections
+	TLS         TLS
+	Gzip        GZip
+	Cache       FileCache
+	Sendfile    Sendfile
+	// MaxProcessNumber workers, bounding the requests answered at the same time. Idle connections hold none
 	MaxProcessNumber int
+	Site
+}
+
+// Site Settings of a single site, selected through the Host header (or SNI, for certificates). File cache and
+// compressed cache are shared among sites, and configured in [minosse] only
+type Site struct {
+	// Hosts names the site answers to. A leading "*." matches any subdomain
+	Hosts []string
+	// Default serves requests for unknown hosts with this site, instead of the one described by [minosse]. [minosse] may
+	// then leave out its webroot, only holding the settings inherited by every site
+	Default bool
+	WebRoot string
+	// Symlinks policy for symlinks inside WebRoot: "follow" (default), "deny" or "ownerMatch"
+	Symlinks string
+	// Index files looked for, in order, when a directory is requested
+	Index []string
+	// NoIndexStatus status code (403 or 404) for directories without any index file
+	NoIndexStatus int
+	// CacheControl value of the Cache-Control header sent along with files
+	CacheControl string
+	Compression  Compression
+	ETag         ETag
+	Access       Access
+	Autoindex    Autoindex
+	TryFiles     TryFiles
+	// ErrorPages maps status codes to pages inside WebRoot, either static files or html/template files (.tmpl, .gohtml)
+	ErrorPages map[string]string
+	// Certificate served to TLS clients asking for one of Hosts through SNI
+	Certificate Certificate
 }
 
-// GZip configurations
+// Certificate An x509 certificate and its private key
+type Certificate struct {
+	X509CertPath string
+	X509KeyPath  string
+}
+
+// GZip configurations. Deprecated: superseded by Compression, kept for backward compatibility
 type GZip struct {
 	Enabled   bool
 	Level     int
 	Threshold int64
 	Exclude   string
+	// Stream compresses files bigger than MaxBufferedSize while sending them with chunked transfer encoding, instead
+	// of compressing them in memory first
+	Stream          bool
+	MaxBufferedSize int64
+}
+
+// Compression configurations
+type Compression struct {
+	Enabled bool
+	// Encodings server-side preference order among "br", "zstd", "gzip" and "identity"
+	Encodings   []string
+	GzipLevel   int
+	BrotliLevel int
+	ZstdLevel   int
+	Threshold   int64
+	Exclude     string
+	// Stream compresses files bigger than MaxBufferedSize while sending them with chunked transfer encoding. Without
+	// it, such files are sent uncompressed, since only files up to MaxBufferedSize are compressed in memory
+	Stream          bool
+	MaxBufferedSize int64
+	// Precompressed serves sidecar files (app.js.br, app.js.zst, app.js.gz) found next to the requested one, when the
+	// client accepts their encoding, instead of compressing on the fly
+	Precompressed bool
+	Cache         CompressionCache
+}
+
+// CompressionCache In-memory LRU cache of compressed bodies, bounded both in size and number of entries
+type CompressionCache struct {
+	Enabled    bool
+	MaxBytes   int64
+	MaxEntries int
+}
+
+// FileCache In-memory cache of small files, invalidated through filesystem notifications on the web root
+type FileCache struct {
+	Enabled bool
+	// MaxFileSize files bigger than this are always read from disk
+	MaxFileSize int64
+	MaxBytes    int64
+	MaxEntries  int
+	// PollInterval seconds between modification time checks, only used when filesystem notifications are
+	// unavailable or the web root goes through symlinks
+	PollInterval int
+}
+
+// Sendfile Zero-copy transfer of uncompressed files over plain TCP connections through explicit sendfile(2) calls
+// (Linux only). Otherwise, the Go runtime takes its own zero-copy path once the write buffer is full
+type Sendfile struct {
+	Enabled bool
+	// ChunkSize maximum number of bytes handed to a single sendfile(2) call
+	ChunkSize int64
+}
+
+// TryFiles nginx-like try_files, mostly useful for single page applications with client-side routing
+type TryFiles struct {
+	// Candidates paths tried in order, where "$uri" stands for the request path, e.g. ["$uri", "$uri.html", "/index.html"].
+	// The first existing one is served
+	Candidates []string
+	// NoFallbackExtensions extensions of request paths ("*" for any) which never fall back to candidates not
+	// containing "$uri", so that missing assets still get a 404
+	NoFallbackExtensions []string
+}
+
+// Autoindex Directory listings for directories without an index file
+type Autoindex struct {
+	Enabled bool
+	// Prefixes URL prefixes of the directories which can be listed. Defaults to every directory
+	Prefixes []string
+}
+
+// Access Rules hiding files under WebRoot from clients, which get a 404 instead
+type Access struct {
+	// AllowDotfiles serves paths with a segment starting with a dot, which are hidden by default
+	AllowDotfiles bool
+	// DotfilesAllowlist segments starting with a dot served anyway. Defaults to [".well-known"]
+	DotfilesAllowlist []string
+	// Deny glob patterns matched against every path segment, or regular expressions matched against the whole path
+	// when prefixed by "regexp:"
+	Deny []string
+	// ClientCertPrefixes URL prefixes only served to TLS clients presenting a certificate signed by one of the CAs in
+	// X509RootCAPath, whatever the client authentication mode ("none" is raised to "request"). Other clients get a 403
+	ClientCertPrefixes []string
+}
+
+// ETag configurations
+type ETag struct {
+	Enabled bool
+	// Weak marks generated entity tags as weak validators
+	Weak bool
+	// Hash derives entity tags from a sha256 digest of the file content instead of its size and modification time
+	Hash bool
 }
 
 // Connections Configurations regarding connections
@@ -31,6 +166,10 @@ type Connections struct {
 	ReadTimeout    int
 	WriteTimeout   int
 	MaxConnections int
+	// IdleTimeout seconds a keep-alive connection is kept open while waiting for the next request
+	IdleTimeout int
+	// MaxRequests maximum number of requests served on a single connection. Negative values mean no limit
+	MaxRequests int
 }
 
 // Zap Configuration for zap logger
@@ -39,9 +178,60 @@ type Zap struct {
 }
 
 type TLS struct {
-	Enabled        bool
-	Port           int
-	X509CertPath   string
-	X509KeyPath    string
+	Enabled      bool
+	Port         int
+	X509CertPath string
+	X509KeyPath  string
+	// X509RootCAPath certificate authorities client certificates are verified against
 	X509RootCAPath string
+	// ClientAuth client certificate authentication mode: "none", "request", "require" or "verify"
+	ClientAuth string
+	// Certificates additional key pairs, chosen through SNI among the ones valid for the requested server name
+	Certificates []Certificate
+	// MinVersion and MaxVersion TLS protocol versions accepted: "1.0", "1.1", "1.2" or "1.3". MinVersion defaults
+	// to "1.2", MaxVersion to the highest one supported
+	MinVersion string
+	MaxVersion string
+	// CipherSuites IANA names of the cipher suites enabled for TLS 1.0-1.2, in no particular order. TLS 1.3 suites are
+	// not configurable
+	CipherSuites []string
+	// Curves elliptic curves used for key exchanges, in preference order: "X25519", "P256", "P384" or "P521"
+	Curves []string
+	// ALPN protocols advertised through Application-Layer Protocol Negotiation, e.g. ["http/1.1"]
+	ALPN                   []string
+	SessionTicketsDisabled bool
+	// SessionTicketKeyFile file holding one or more 32 bytes session ticket keys, checked for changes every minute so
+	// that it can be shared and rotated across instances. The first key encrypts new tickets
+	SessionTicketKeyFile string
+	// RedirectHTTP answers requests on the plain HTTP port with a redirect to HTTPS, except for ACME HTTP-01 challenges
+	RedirectHTTP bool
+	// RedirectStatus status code of redirects to HTTPS: 301 (default) or 308, which preserves the request method
+	RedirectStatus int
+	HSTS           HSTS
+	ACME           ACME
+	// AutoSelfSigned generates a self-signed certificate at startup, for the hosts of every site plus localhost. Meant
+	// for development only, since clients will not trust it
+	AutoSelfSigned bool
+}
+
+// ACME Certificates obtained and renewed automatically from an ACME CA (e.g. Let's Encrypt), through HTTP-01 or
+// TLS-ALPN-01 challenges. HTTP-01 needs the plain listener to be reachable on port 80, TLS-ALPN-01 the TLS one on 443
+type ACME struct {
+	Enabled bool
+	// DirectoryURL of the ACME CA. Defaults to Let's Encrypt production
+	DirectoryURL string
+	Email        string
+	// CacheDir directory where account key and certificates are stored
+	CacheDir string
+	// Hosts names certificates are requested for. Defaults to the non-wildcard hosts of every site
+	Hosts []string
+	// RootCAPath CA the ACME directory is served with, when not a public one (e.g. Pebble)
+	RootCAPath string
+}
+
+// HSTS HTTP Strict Transport Security, sent along with every response over TLS when MaxAge is positive
+type HSTS struct {
+	MaxAge            int
+	IncludeSubDomains bool
+	Preload           bool
 }
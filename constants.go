@@ -1,8 +1,29 @@
 package main
 
+// CONFIG_FILE_PATH location of the minosse configuration file
+const CONFIG_FILE_PATH string = "./config/config.example.toml"
+
 const HTTP_POST_METHOD string = "POST"
 const HTTP_GET_METHOD string = "GET"
+const HTTP_HEAD_METHOD string = "HEAD"
+const HTTP_OPTIONS_METHOD string = "OPTIONS"
 const HTTP_NOT_FOUND string = "Not Found"
+const HTTP_BAD_REQUEST string = "Bad Request"
+const HTTP_FORBIDDEN string = "Forbidden"
+const HTTP_FORBIDDEN_BODY string = "403 Forbidden"
+const HTTP_MOVED_PERMANENTLY string = "Moved Permanently"
+const HTTP_MOVED_PERMANENTLY_BODY string = "301 Moved Permanently"
+const HTTP_PERMANENT_REDIRECT string = "Permanent Redirect"
+const HTTP_PERMANENT_REDIRECT_BODY string = "308 Permanent Redirect"
+const HTTP_BAD_REQUEST_BODY string = "400 Bad Request"
+const HTTP_NOT_MODIFIED string = "Not Modified"
+const HTTP_PARTIAL_CONTENT string = "Partial Content"
+const HTTP_RANGE_NOT_SATISFIABLE string = "Range Not Satisfiable"
+const HTTP_RANGE_NOT_SATISFIABLE_BODY string = "416 Range Not Satisfiable"
+const HTTP_PRECONDITION_FAILED string = "Precondition Failed"
+const HTTP_PRECONDITION_FAILED_BODY string = "412 Precondition Failed"
+const HTTP_NOT_ACCEPTABLE string = "Not Acceptable"
+const HTTP_NOT_ACCEPTABLE_BODY string = "406 Not Acceptable"
 const HTTP_NOT_ALLOWED string = "Method Not Allowed"
 const HTTP_OK string = "Ok"
 const HTTP_NOT_FOUND_BODY string = "404 Not Found"
@@ -15,15 +36,39 @@ const HEADER_CONTENT_LENGTH string = "Content-Length"
 const HEADER_CACHE_CONTROL string = "Cache-Control"
 const HEADER_CACHE_CONTROL_DEFAULT_VALUE string = "public, max-age=604800"
 const HEADER_CONNECTION string = "Connection"
+const HEADER_STRICT_TRANSPORT_SECURITY string = "Strict-Transport-Security"
 const HEADER_CONNECTION_CLOSE string = "close"
+const HEADER_CONNECTION_KEEP_ALIVE string = "keep-alive"
 const HEADER_LAST_MODIFIED string = "Last-Modified"
 const HEADER_DATE string = "Date"
 const HEADER_SERVER string = "Server"
 const HEADER_SERVER_VALUE string = "Minosse"
 const HEADER_CONTENT_ENCODING string = "Content-Encoding"
+const HEADER_ACCEPT_ENCODING string = "Accept-Encoding"
+const HEADER_VARY string = "Vary"
+const HEADER_TRANSFER_ENCODING string = "Transfer-Encoding"
+const TRANSFER_ENCODING_CHUNKED string = "chunked"
+const HEADER_ALLOW string = "Allow"
+const HEADER_LOCATION string = "Location"
+const HEADER_ACCEPT string = "Accept"
+const MIME_JSON string = "application/json"
+const HEADER_ETAG string = "ETag"
+const HEADER_IF_MATCH string = "If-Match"
+const HEADER_IF_NONE_MATCH string = "If-None-Match"
+const HEADER_IF_MODIFIED_SINCE string = "If-Modified-Since"
+const HEADER_IF_UNMODIFIED_SINCE string = "If-Unmodified-Since"
+const HEADER_RANGE string = "Range"
+const HEADER_IF_RANGE string = "If-Range"
+const HEADER_ACCEPT_RANGES string = "Accept-Ranges"
+const HEADER_CONTENT_RANGE string = "Content-Range"
+const RANGE_UNIT_BYTES string = "bytes"
+const HEADER_ALLOW_VALUE string = "GET, HEAD, OPTIONS"
 const GENERIC_ERROR_MESSAGE_LOG string = "Error reading request"
 const CONNECTION_ERROR_MESSAGE_LOG string = "Error accepting new connection"
 const HTTP_INTERNAL_SERVER_ERROR string = "500 Internal server error"
 const TCP_PROTOCOL string = "TCP"
 const TLS_PROTOCOL string = "TLS"
 const GZIP string = "gzip"
+const BROTLI string = "br"
+const ZSTD string = "zstd"
+const IDENTITY string = "identity"
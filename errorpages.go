@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errorPageData Data available to error page templates
+type errorPageData struct {
+	StatusCode int
+	Status     string
+	Path       string
+}
+
+// parsedTemplate A parsed error page template, along with the modification time of its file
+type parsedTemplate struct {
+	modTime  time.Time
+	template *template.Template
+}
+
+var templatesMutex sync.Mutex
+var templates = make(map[string]parsedTemplate)
+
+// isTemplate Reports whether an error page is a Go html/template rather than a static file
+func isTemplate(page string) bool {
+	ext := path.Ext(page)
+	return ext == ".tmpl" || ext == ".gohtml"
+}
+
+// applyErrorPage Replaces the built-in body of an error response with the page configured for its status code by the
+// site serving req, if any. When the page cannot be loaded the built-in body is kept
+func applyErrorPage(response *Response, req *http.Request) {
+	vh := selectVirtualHost(req.Host)
+	page, ok := vh.ErrorPages[strconv.Itoa(response.statusCode)]
+	if !ok {
+		return
+	}
+
+	body, contentType, err := vh.renderErrorPage(page, errorPageData{StatusCode: response.statusCode, Status: response.status, Path: req.URL.Path})
+	if err != nil {
+		logChannel.error("Error while loading error page, using the built-in one", err)
+		return
+	}
+	response.SetBody(body, contentType)
+}
+
+func (vh *virtualHost) renderErrorPage(page string, data errorPageData) ([]byte, string, error) {
+	pathFile, err := vh.resolver.resolvePath(page)
+	if err != nil {
+		return nil, "", err
+	}
+	f, err := openFile(pathFile)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !isTemplate(page) {
+		body, err := ioutil.ReadAll(f)
+		return body, mime.TypeByExtension(path.Ext(page)), err
+	}
+
+	templatesMutex.Lock()
+	parsed, ok := templates[pathFile]
+	templatesMutex.Unlock()
+	if !ok || !parsed.modTime.Equal(stat.ModTime()) {
+		content, err := ioutil.ReadAll(f)
+		if err != nil {
+			return nil, "", err
+		}
+		tmpl, err := template.New(path.Base(page)).Parse(string(content))
+		if err != nil {
+			return nil, "", err
+		}
+		parsed = parsedTemplate{modTime: stat.ModTime(), template: tmpl}
+		templatesMutex.Lock()
+		templates[pathFile] = parsed
+		templatesMutex.Unlock()
+	}
+
+	var body bytes.Buffer
+	if err := parsed.template.Execute(&body, data); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), "text/html; charset=utf-8", nil
+}
+
+// validateErrorPages Checks that error pages are configured for error status codes only, with absolute paths
+func validateErrorPages(pages map[string]string) bool {
+	for code, page := range pages {
+		statusCode, err := strconv.Atoi(code)
+		if err != nil || statusCode < 400 || statusCode > 599 || !strings.HasPrefix(page, "/") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestErrorPages(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.ErrorPages = map[string]string{
+		"404": "/errors/404.html",
+		"403": "/errors/403.tmpl",
+		"405": "/errors/405.gohtml",
+		"400": "/errors/missing.html",
+		"412": "/errors/412.txt",
+	}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{
+		"errors/404.html":   "<h1>Custom not found</h1>",
+		"errors/403.tmpl":   "<h1>{{.StatusCode}} {{.Status}}</h1><p>{{.Path}}</p>",
+		"errors/405.gohtml": "{{.StatusCode}}",
+		"errors/412.txt":    "precondition",
+		"empty/file.txt":    "file",
+		"<script>/file.txt": "file",
+		"a.txt":             "a",
+	})
+	addr := startTestServer(t)
+
+	tests := []struct {
+		name        string
+		request     string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"static page", rawRequest("GET", "/missing"), 404, "text/html; charset=utf-8", "<h1>Custom not found</h1>"},
+		{"static text page", rawRequest("GET", "/a.txt", `If-Match: "other"`), 412, "text/plain; charset=utf-8", "precondition"},
+		{"template", rawRequest("GET", "/empty/"), 403, "text/html; charset=utf-8", "<h1>403 Forbidden</h1><p>/empty/</p>"},
+		{"template escaping", rawRequest("GET", "/%3Cscript%3E/"), 403, "text/html; charset=utf-8", "<h1>403 Forbidden</h1><p>/&lt;script&gt;/</p>"},
+		{"another template", rawRequest("POST", "/a.txt"), 405, "text/html; charset=utf-8", "405"},
+		{"missing page", rawRequest("GET", "/a%2fb"), 400, "text/plain; charset=utf-8", HTTP_BAD_REQUEST_BODY},
+		{"no page configured", rawRequest("GET", "/a.txt", "Range: bytes=10-"), 416, "text/plain; charset=utf-8", HTTP_RANGE_NOT_SATISFIABLE_BODY},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := fetch(t, addr, test.request)
+			if res.StatusCode != test.status || res.body != test.body {
+				t.Fatalf("got %d %q, want %d %q", res.StatusCode, res.body, test.status, test.body)
+			}
+			if got := res.Header.Get("Content-Type"); got != test.contentType {
+				t.Errorf("got Content-Type %q, want %q", got, test.contentType)
+			}
+			if res.ContentLength != int64(len(test.body)) {
+				t.Errorf("got Content-Length %d, want %d", res.ContentLength, len(test.body))
+			}
+		})
+	}
+
+	t.Run("HEAD", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("HEAD", "/missing"))
+		if res.StatusCode != 404 || res.body != "" || res.Header.Get("Content-Length") != strconv.Itoa(len("<h1>Custom not found</h1>")) {
+			t.Errorf("got %d with Content-Length %q and body %q, want 404 with the length of the page and no body", res.StatusCode, res.Header.Get("Content-Length"), res.body)
+		}
+	})
+
+	t.Run("template reloaded", func(t *testing.T) {
+		writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"errors/405.gohtml": "changed {{.StatusCode}}"})
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(filepath.Join(conf.Minosse.WebRoot, "errors", "405.gohtml"), later, later); err != nil {
+			t.Fatal(err)
+		}
+		if res := fetch(t, addr, rawRequest("POST", "/a.txt")); res.body != "changed 405" {
+			t.Errorf("got %q, want the updated template", res.body)
+		}
+	})
+}
+
+func TestValidateErrorPages(t *testing.T) {
+	tests := []struct {
+		pages map[string]string
+		valid bool
+	}{
+		{nil, true},
+		{map[string]string{"404": "/404.html", "503": "/503.tmpl"}, true},
+		{map[string]string{"200": "/ok.html"}, false},
+		{map[string]string{"600": "/600.html"}, false},
+		{map[string]string{"notfound": "/404.html"}, false},
+		{map[string]string{"404": "404.html"}, false},
+	}
+	for _, test := range tests {
+		if valid := validateErrorPages(test.pages); valid != test.valid {
+			t.Errorf("validateErrorPages(%v) = %t, want %t", test.pages, valid, test.valid)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// staticFile The content served for a request: either an open *os.File or a copy of it held in memory
+type staticFile interface {
+	io.ReadSeeker
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// cachedContent Content and metadata of a file held by the file cache
+type cachedContent struct {
+	data []byte
+	stat os.FileInfo
+}
+
+// cachedFile A staticFile reading from a cachedContent. Every request gets its own reader over the shared data
+type cachedFile struct {
+	*bytes.Reader
+	stat os.FileInfo
+}
+
+func (c *cachedFile) Close() error {
+	return nil
+}
+
+func (c *cachedFile) Stat() (os.FileInfo, error) {
+	return c.stat, nil
+}
+
+var fileCache *lruCache
+
+// openFile Opens the file to be served, going through the file cache when it is enabled. Regular files under the
+// configured size limit are loaded in memory on first access
+func openFile(pathFile string) (staticFile, error) {
+	if fileCache == nil {
+		return os.Open(pathFile)
+	}
+
+	key := filepath.Clean(pathFile)
+	if value, ok := fileCache.get(key); ok {
+		fileCache.hit()
+		content := value.(*cachedContent)
+		return &cachedFile{Reader: bytes.NewReader(content.data), stat: content.stat}, nil
+	}
+	fileCache.miss()
+
+	f, err := os.Open(pathFile)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil || !stat.Mode().IsRegular() || stat.Size() > config.Minosse.Cache.MaxFileSize {
+		return f, nil
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, stat.Size()))
+	if err != nil {
+		return nil, err
+	}
+	// The file changed while reading it: serve what was read, but do not cache it
+	if int64(len(data)) == stat.Size() && statUnchanged(pathFile, stat) {
+		fileCache.add(key, &cachedContent{data: data, stat: stat}, stat.Size())
+	}
+	return &cachedFile{Reader: bytes.NewReader(data), stat: stat}, nil
+}
+
+// statUnchanged Reports whether pathFile is still the file described by stat, with the same size and modification
+// time. Files replaced through a rename are told apart even when both match
+func statUnchanged(pathFile string, stat os.FileInfo) bool {
+	current, err := os.Stat(pathFile)
+	return err == nil && os.SameFile(current, stat) && current.Size() == stat.Size() && current.ModTime().Equal(stat.ModTime())
+}
+
+// watchWebRoot Invalidates cached files as soon as they change on disk, through filesystem notifications
+// (e.g. inotify) on every directory of the web root. When notifications are unavailable, cached files are
+// checked periodically against their size and modification time instead. Notifications cannot follow symlinks, nor a
+// symlinked web root being pointed somewhere else (e.g. current -> releases/N): as soon as symlinks are involved,
+// polling runs alongside them. Returns once stop is closed, polling included
+func watchWebRoot(webRoot string, stop <-chan struct{}) {
+	pollInterval := time.Second * time.Duration(config.Minosse.Cache.PollInterval)
+	// Directories are watched through their real path, while cache keys are made of paths below webRoot
+	realRoot, err := filepath.EvalSymlinks(webRoot)
+	var watcher *fsnotify.Watcher
+	if err == nil {
+		watcher, err = fsnotify.NewWatcher()
+	}
+	hasSymlinks := realRoot != webRoot
+	if err == nil {
+		var found bool
+		found, err = addWatchRecursive(watcher, realRoot)
+		hasSymlinks = hasSymlinks || found
+	}
+	if err != nil {
+		logChannel.channel <- Log{level: WARNING, message: "Filesystem notifications unavailable, falling back to polling for file cache invalidation", data: []zap.Field{zap.Error(err)}}
+		if watcher != nil {
+			watcher.Close()
+		}
+		pollFileCache(pollInterval, stop)
+		return
+	}
+	defer watcher.Close()
+
+	var poller sync.WaitGroup
+	defer poller.Wait()
+	polling := false
+	startPolling := func() {
+		if !polling {
+			polling = true
+			logChannel.channel <- Log{level: INFO, message: "Web root goes through symlinks, polling for file cache invalidation along with filesystem notifications", data: []zap.Field{zap.String("webroot", webRoot)}}
+			poller.Add(1)
+			go func() {
+				defer poller.Done()
+				pollFileCache(pollInterval, stop)
+			}()
+		}
+	}
+	if hasSymlinks {
+		startPolling()
+	}
+	cacheKey := func(name string) string {
+		return webRoot + strings.TrimPrefix(name, realRoot)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			key := cacheKey(event.Name)
+			fileCache.remove(key)
+			switch {
+			case event.Op&fsnotify.Create != 0:
+				if stat, err := os.Lstat(event.Name); err == nil && stat.Mode()&os.ModeSymlink != 0 {
+					startPolling()
+				} else if err == nil && stat.IsDir() {
+					found, err := addWatchRecursive(watcher, event.Name)
+					if err != nil {
+						logChannel.error("Error while watching new directory", err)
+					}
+					if found {
+						startPolling()
+					}
+				}
+			case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+				// Whole directories may be gone
+				prefix := key + string(filepath.Separator)
+				fileCache.removeIf(func(key string) bool { return strings.HasPrefix(key, prefix) })
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been lost (e.g. inotify queue overflow), nothing cached can be trusted anymore
+			logChannel.error("Error while watching web root, purging file cache", err)
+			fileCache.removeIf(func(string) bool { return true })
+		}
+	}
+}
+
+// addWatchRecursive Watches root and every directory below it, reporting whether any symlink was found on the way.
+// Symlinks are not followed
+func addWatchRecursive(watcher *fsnotify.Watcher, root string) (bool, error) {
+	hasSymlinks := false
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			hasSymlinks = true
+		}
+		if info.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+	return hasSymlinks, err
+}
+
+// pollFileCache Drops cached files whose size or modification time changed on disk, until stop is closed. Checks do not
+// count as uses of the cached files
+func pollFileCache(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, key := range fileCache.keys() {
+			value, ok := fileCache.peek(key)
+			if !ok {
+				continue
+			}
+			cached := value.(*cachedContent).stat
+			stat, err := os.Stat(key)
+			if err != nil || !stat.ModTime().Equal(cached.ModTime()) || stat.Size() != cached.Size() {
+				fileCache.remove(key)
+			}
+		}
+	}
+}
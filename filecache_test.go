@@ -0,0 +1,230 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// enableFileCache Turns the file cache on until the end of the test
+func enableFileCache(t *testing.T, maxFileSize int64) {
+	t.Helper()
+	previousConfig, previousCache := config.Minosse.Cache, fileCache
+	t.Cleanup(func() { config.Minosse.Cache, fileCache = previousConfig, previousCache })
+	config.Minosse.Cache = FileCache{Enabled: true, MaxFileSize: maxFileSize, MaxBytes: 1 << 20, MaxEntries: 100, PollInterval: 1}
+	fileCache = newLRUCache(config.Minosse.Cache.MaxBytes, config.Minosse.Cache.MaxEntries)
+}
+
+// readFile Reads pathFile through openFile
+func readFile(t *testing.T, pathFile string) (staticFile, string) {
+	t.Helper()
+	f, err := openFile(pathFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, string(data)
+}
+
+func TestOpenFile(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"small.txt": "small", "large.txt": strings.Repeat("large", 100)})
+	enableFileCache(t, 100)
+
+	if _, ok := fileCache.get(filepath.Join(root, "small.txt")); ok {
+		t.Fatal("file cached before being opened")
+	}
+	for i := 0; i < 2; i++ {
+		f, data := readFile(t, filepath.Join(root, ".", "small.txt"))
+		if _, ok := f.(*cachedFile); !ok || data != "small" {
+			t.Fatalf("got %T with %q, want a cached small", f, data)
+		}
+		if stat, _ := f.Stat(); stat.Size() != 5 || stat.Name() != "small.txt" {
+			t.Errorf("got stat %s of %d bytes, want small.txt of 5", stat.Name(), stat.Size())
+		}
+	}
+	if fileCache.hits != 1 || fileCache.misses != 1 {
+		t.Errorf("got %d hits and %d misses, want 1 and 1", fileCache.hits, fileCache.misses)
+	}
+
+	// Files over the size limit and directories are always read from disk
+	if f, data := readFile(t, filepath.Join(root, "large.txt")); data != strings.Repeat("large", 100) {
+		t.Errorf("got %d bytes, want 500", len(data))
+	} else if _, ok := f.(*os.File); !ok {
+		t.Errorf("got %T for a file over the size limit, want *os.File", f)
+	}
+	f, err := openFile(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, ok := f.(*os.File); !ok {
+		t.Errorf("got %T for a directory, want *os.File", f)
+	}
+	if len(fileCache.keys()) != 1 {
+		t.Errorf("got cached keys %v, want small.txt only", fileCache.keys())
+	}
+
+	if _, err := openFile(filepath.Join(root, "missing.txt")); !os.IsNotExist(err) {
+		t.Errorf("got error %v for a missing file, want not exist", err)
+	}
+}
+
+func TestFileCacheServing(t *testing.T) {
+	conf := newTestConfig(t)
+	content := strings.Repeat("0123456789", 10)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"digits.txt": content})
+	addr := startTestServer(t)
+	enableFileCache(t, 1<<10)
+
+	for i := 0; i < 2; i++ {
+		full := fetch(t, addr, rawRequest("GET", "/digits.txt"))
+		if full.StatusCode != 200 || full.body != content || full.ContentLength != 100 {
+			t.Fatalf("got %d with %d bytes, want the whole file", full.StatusCode, len(full.body))
+		}
+		partial := fetch(t, addr, rawRequest("GET", "/digits.txt", "Range: bytes=-5"))
+		if partial.StatusCode != 206 || partial.body != content[95:] {
+			t.Errorf("got %d %q, want 206 %q", partial.StatusCode, partial.body, content[95:])
+		}
+	}
+	if hits, misses := atomic.LoadUint64(&fileCache.hits), atomic.LoadUint64(&fileCache.misses); hits != 3 || misses != 1 {
+		t.Errorf("got %d hits and %d misses, want 3 and 1", hits, misses)
+	}
+}
+
+// waitForContent Reads pathFile through the file cache until it has the expected content
+func waitForContent(t *testing.T, pathFile, content string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, data := readFile(t, pathFile); data == content {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("%s: got stale content %q, want %q", pathFile, data, content)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// startWatcher Watches webRoot until the end of the test
+func startWatcher(t *testing.T, webRoot string) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		watchWebRoot(webRoot, stop)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		close(stop)
+		<-done
+	})
+	// Lets the watcher register its directories
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestFileCacheInvalidation(t *testing.T) {
+	enableFileCache(t, 1<<20)
+
+	// A deploy-like layout: the web root is a symlink to a release directory, which holds a symlink to a directory
+	// of its own
+	base := t.TempDir()
+	release := filepath.Join(base, "releases", "1")
+	writeTestFiles(t, release, map[string]string{"a.txt": "v1", "docs/b.txt": "v1"})
+	if err := os.Symlink(filepath.Join(release, "docs"), filepath.Join(release, "linked")); err != nil {
+		t.Fatal(err)
+	}
+	webRoot := filepath.Join(base, "current")
+	if err := os.Symlink(release, webRoot); err != nil {
+		t.Fatal(err)
+	}
+	startWatcher(t, webRoot)
+
+	for _, name := range []string{"a.txt", "docs/b.txt", "linked/b.txt"} {
+		waitForContent(t, filepath.Join(webRoot, filepath.FromSlash(name)), "v1")
+	}
+	writeTestFiles(t, release, map[string]string{"a.txt": "v2 updated", "docs/b.txt": "v2 updated"})
+	for _, name := range []string{"a.txt", "docs/b.txt", "linked/b.txt"} {
+		waitForContent(t, filepath.Join(webRoot, filepath.FromSlash(name)), "v2 updated")
+	}
+}
+
+func TestPollFileCache(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": "a", "b.txt": "b"})
+	enableFileCache(t, 100)
+	pathA, pathB := filepath.Join(root, "a.txt"), filepath.Join(root, "b.txt")
+	readFile(t, pathA)
+	readFile(t, pathB)
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		pollFileCache(10*time.Millisecond, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// Checks do not promote entries: a.txt stays the least recently used one
+	time.Sleep(50 * time.Millisecond)
+	if keys := fileCache.keys(); len(keys) != 2 || keys[0] != pathB || keys[1] != pathA {
+		t.Errorf("got keys %v after polling, want b.txt then a.txt", keys)
+	}
+
+	writeTestFiles(t, root, map[string]string{"a.txt": "a changed"})
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := fileCache.peek(pathA); !ok {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("changed file still cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := fileCache.peek(pathB); !ok {
+		t.Error("unchanged file dropped")
+	}
+}
+
+func TestStatUnchanged(t *testing.T) {
+	root := t.TempDir()
+	writeTestFiles(t, root, map[string]string{"a.txt": "same", "other.txt": "same"})
+	pathFile := filepath.Join(root, "a.txt")
+	stat, err := os.Stat(pathFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statUnchanged(pathFile, stat) {
+		t.Error("untouched file reported as changed")
+	}
+
+	// Replaced through a rename with the same size and modification time
+	other := filepath.Join(root, "other.txt")
+	if err := os.Chtimes(other, stat.ModTime(), stat.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(other, pathFile); err != nil {
+		t.Fatal(err)
+	}
+	if statUnchanged(pathFile, stat) {
+		t.Error("replaced file reported as unchanged")
+	}
+
+	stat, _ = os.Stat(pathFile)
+	writeTestFiles(t, root, map[string]string{"a.txt": "longer"})
+	if statUnchanged(pathFile, stat) {
+		t.Error("rewritten file reported as unchanged")
+	}
+	os.Remove(pathFile)
+	if statUnchanged(pathFile, stat) {
+		t.Error("removed file reported as unchanged")
+	}
+}
@@ -3,10 +3,12 @@ module minosse
 go 1.15
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/fatih/color v1.10.0
-	github.com/klauspost/compress v1.13.3 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
+	github.com/klauspost/compress v1.13.3
 	github.com/klauspost/cpuid v0.0.0-20180405133222-e7e905edc00e // indirect
-	github.com/libp2p/go-reuseport v0.0.2 // indirect
+	github.com/libp2p/go-reuseport v0.0.2
 	github.com/mattn/go-isatty v0.0.12 // indirect
 	github.com/pelletier/go-toml v1.8.1
 	github.com/stretchr/testify v1.7.0 // indirect
@@ -14,6 +16,7 @@ require (
 	go.uber.org/multierr v1.6.0 // indirect
 	go.uber.org/ratelimit v0.1.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20210513164829-c07d793c2f9a
 	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/ratelimit"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testLogs Everything logged through the log channel while running tests
+var testLogs *observer.ObservedLogs
+
+func TestMain(m *testing.M) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	testLogs = logs
+	logChannel = newLogChannel(zap.New(core), &config)
+	go logChannel.handleLog()
+	os.Exit(m.Run())
+}
+
+// newTestConfig Resets the global configuration and sites, serving files from a temporary webroot. Tests adjust it
+// before calling startTestServer
+func newTestConfig(t *testing.T) *Config {
+	t.Helper()
+	config = Config{}
+	config.Minosse.WebRoot = t.TempDir()
+	virtualHosts, defaultHost, hostsByName = nil, nil, make(map[string]*virtualHost)
+	return &config
+}
+
+// startTestServer Applies the default values to the global configuration and serves it on a loopback listener with
+// a single worker, until the end of the test. Returns the address of the listener
+func startTestServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveTestListener(t, listener)
+}
+
+// serveTestListener Applies the default values to the global configuration and serves it on listener with a single
+// worker, until the end of the test. Returns the address of the listener
+func serveTestListener(t *testing.T, listener net.Listener) string {
+	t.Helper()
+	return serveTestWorkers(t, listener, 1)
+}
+
+// serveTestWorkers Applies the default values to the global configuration and serves it on listener with n workers,
+// until the end of the test. Returns the address of the listener
+func serveTestWorkers(t *testing.T, listener net.Listener, n int) string {
+	t.Helper()
+	applyDefaultConfigValues(&config)
+
+	connections := make(chan net.Conn)
+	done := make(chan struct{})
+	go func() {
+		serve(connections, newWorkers(n), ratelimit.NewUnlimited())
+		close(done)
+	}()
+	go func() {
+		defer close(connections)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections <- conn
+		}
+	}()
+	t.Cleanup(func() {
+		listener.Close()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+// writeTestFiles Creates the given files, by slash separated path relative to root, along with their directories
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// rawRequest Builds an HTTP/1.1 request for target, with the given header lines
+func rawRequest(method, target string, headers ...string) string {
+	request := method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, header := range headers {
+		request += header + "\r\n"
+	}
+	return request + "\r\n"
+}
+
+// dialTestServer Opens a connection to the test server, closed at the end of the test
+func dialTestServer(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return conn
+}
+
+// testResponse A response along with its whole body
+type testResponse struct {
+	*http.Response
+	body string
+}
+
+// readTestResponse Reads the response to a request with the given method
+func readTestResponse(t *testing.T, reader *bufio.Reader, method string) testResponse {
+	t.Helper()
+	res, err := http.ReadResponse(reader, &http.Request{Method: method})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testResponse{Response: res, body: string(body)}
+}
+
+// roundTrip Sends the raw requests at once on a new connection, then reads the response to each of them. The
+// connection is closed once done, so that the worker can move on to the next one
+func roundTrip(t *testing.T, addr string, requests ...string) []testResponse {
+	t.Helper()
+	conn := dialTestServer(t, addr)
+	defer conn.Close()
+	if _, err := io.WriteString(conn, strings.Join(requests, "")); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	var responses []testResponse
+	for _, request := range requests {
+		responses = append(responses, readTestResponse(t, reader, strings.SplitN(request, " ", 2)[0]))
+	}
+	return responses
+}
+
+// fetch Sends a single request on a new connection and returns its response
+func fetch(t *testing.T, addr string, request string) testResponse {
+	t.Helper()
+	return roundTrip(t, addr, request)[0]
+}
+
+// assertClosed Fails unless the server closes conn without sending anything else
+func assertClosed(t *testing.T, reader *bufio.Reader) {
+	t.Helper()
+	if rest, err := ioutil.ReadAll(reader); err != nil || len(rest) != 0 {
+		t.Errorf("connection not closed by the server: read %q (%v)", rest, err)
+	}
+}
+
+// gunzip Decompresses a gzip body
+func gunzip(t *testing.T, body string) string {
+	t.Helper()
+	reader, err := gzip.NewReader(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path"
+)
+
+var errNoIndex = errors.New("no index file in directory")
+
+// openIndex Opens the first of the configured index files found in the directory addressed by dirURLPath, which
+// must end with a slash. Index files go through the same path resolution as requested files
+func (vh *virtualHost) openIndex(dirURLPath string) (string, staticFile, os.FileInfo, error) {
+	for _, name := range vh.Index {
+		pathFile, err := vh.resolver.resolvePath(dirURLPath + name)
+		if err != nil {
+			continue
+		}
+		f, err := openFile(pathFile)
+		if err != nil {
+			continue
+		}
+		stat, err := f.Stat()
+		if err != nil || !stat.Mode().IsRegular() {
+			f.Close()
+			continue
+		}
+		return pathFile, f, stat, nil
+	}
+	return "", nil, nil, errNoIndex
+}
+
+// directoryLocation Returns the URL of a directory in its canonical form, cleaned and with the trailing slash, query
+// included. A cleaned path never starts with "//", which browsers would take for a redirect to another host
+func directoryLocation(u *url.URL) string {
+	dir := path.Clean("/" + u.Path)
+	if dir != "/" {
+		dir += "/"
+	}
+	location := (&url.URL{Path: dir}).EscapedPath()
+	if u.RawQuery != "" {
+		location += "?" + u.RawQuery
+	}
+	return location
+}
+
+// ResponseNoIndex Response to a directory request without any index file, with the configured status code
+func ResponseNoIndex(statusCode int) Response {
+	if statusCode == 404 {
+		return ResponseNotFound()
+	}
+	return ResponseForbidden()
+}
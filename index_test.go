@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDirectoryIndex(t *testing.T) {
+	files := map[string]string{
+		"index.html":         "root index",
+		"htm/index.htm":      "htm index",
+		"both/index.htm":     "htm index",
+		"both/index.html":    "html index",
+		"empty/file.txt":     "file",
+		"nested/a/index.htm": "nested index",
+	}
+
+	tests := []struct {
+		name          string
+		index         []string
+		noIndexStatus int
+		target        string
+		status        int
+		body          string
+	}{
+		{"root", nil, 0, "/", 200, "root index"},
+		{"index.htm fallback", nil, 0, "/htm/", 200, "htm index"},
+		{"index.html first", nil, 0, "/both/", 200, "html index"},
+		{"configured order", []string{"index.htm", "index.html"}, 0, "/both/", 200, "htm index"},
+		{"nested", nil, 0, "/nested/a/", 200, "nested index"},
+		{"no index", nil, 0, "/empty/", 403, ""},
+		{"no index with 404", nil, 404, "/empty/", 404, ""},
+		{"no index directory", nil, 0, "/nested/", 403, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.Minosse.Index = test.index
+			conf.Minosse.NoIndexStatus = test.noIndexStatus
+			writeTestFiles(t, conf.Minosse.WebRoot, files)
+			addr := startTestServer(t)
+
+			res := fetch(t, addr, rawRequest("GET", test.target))
+			if res.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", res.StatusCode, test.status)
+			}
+			if test.body != "" && res.body != test.body {
+				t.Errorf("got %q, want %q", res.body, test.body)
+			}
+			if test.status == 200 && res.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+				t.Errorf("got Content-Type %q, want the one of the index file", res.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestDirectoryRedirect(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"docs/index.html": "index", "docs/a b/index.html": "index", "example.com/index.html": "index"})
+	addr := startTestServer(t)
+
+	tests := []struct {
+		target   string
+		location string
+	}{
+		{"/docs", "/docs/"},
+		{"/docs?q=1", "/docs/?q=1"},
+		{"/docs/a%20b", "/docs/a%20b/"},
+		// Never protocol-relative, which browsers would follow to another host
+		{"//example.com", "/example.com/"},
+		{"///example.com", "/example.com/"},
+		{"/docs/..//example.com", "/example.com/"},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, rawRequest("GET", test.target))
+		if res.StatusCode != 301 {
+			t.Errorf("GET %s: got status %d, want 301", test.target, res.StatusCode)
+		}
+		if location := res.Header.Get("Location"); location != test.location {
+			t.Errorf("GET %s: got Location %q, want %q", test.target, location, test.location)
+		}
+	}
+}
@@ -130,18 +130,20 @@ func (logChannel *LogChannel) logWholeRequest(request *http.Request, response *R
 		sb.WriteString(", ")
 	}
 
-	logChannel.channel <- Log{
-		level:   INFO,
-		message: ">>>>",
-		data: []zap.Field{
-			zap.Int("response_code", response.statusCode),
-			zap.String("response_status", response.status),
-			zap.String("request_method", request.Method),
-			zap.String("request_uri", request.URL.String()),
-			zap.String("request_headers", sb.String()),
-			zap.String("request_body", string(body)),
-			zap.String("request_remote_address", request.RemoteAddr),
-			zap.Duration("duration", end.Sub(*start)),
-		},
+	data := []zap.Field{
+		zap.Int("response_code", response.statusCode),
+		zap.String("response_status", response.status),
+		zap.String("request_method", request.Method),
+		zap.String("request_uri", request.URL.String()),
+		zap.String("request_headers", sb.String()),
+		zap.String("request_body", string(body)),
+		zap.String("request_remote_address", request.RemoteAddr),
+		zap.Duration("duration", end.Sub(*start)),
+	}
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		clientCert := request.TLS.PeerCertificates[0]
+		data = append(data, zap.String("client_cert_subject", clientCert.Subject.String()), zap.String("client_cert_serial", clientCert.SerialNumber.String()))
 	}
+
+	logChannel.channel <- Log{level: INFO, message: ">>>>", data: data}
 }
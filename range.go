@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidRange = errors.New("invalid range")
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// byteRange A single range of a byte range request, already resolved against the size of the file
+type byteRange struct {
+	start, length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRange Parses a Range header as described in RFC 7233 section 2.1. Syntactically invalid headers produce
+// errInvalidRange and should be ignored, while errUnsatisfiableRange means that none of the ranges overlaps the file
+func parseRange(header string, size int64) ([]byteRange, error) {
+	if !strings.HasPrefix(header, RANGE_UNIT_BYTES+"=") {
+		return nil, errInvalidRange
+	}
+
+	var ranges []byteRange
+	noOverlap := false
+	for _, spec := range strings.Split(header[len(RANGE_UNIT_BYTES)+1:], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		dash := strings.IndexByte(spec, '-')
+		if dash < 0 {
+			return nil, errInvalidRange
+		}
+		first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+		var r byteRange
+		if first == "" {
+			// Suffix range: the last N bytes of the file
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+			}
+			if start >= size {
+				noOverlap = true
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			r = byteRange{start: start, length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 && noOverlap {
+		return nil, errUnsatisfiableRange
+	}
+	return ranges, nil
+}
+
+// checkIfRange Reports whether the Range header of req should be honoured, according to its If-Range validator
+func checkIfRange(req *http.Request, etag string, modTime time.Time) bool {
+	ifRange := req.Header.Get(HEADER_IF_RANGE)
+	if ifRange == "" {
+		return true
+	}
+	if candidate, _ := scanETag(ifRange); candidate != "" {
+		return etagStrongMatch(candidate, etag)
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Equal(modTime.Truncate(time.Second))
+}
+
+func rangesLength(ranges []byteRange) (length int64) {
+	for _, r := range ranges {
+		length += r.length
+	}
+	return
+}
+
+// serveRanges Writes a 206 Partial Content response. A single range is sent as is, while multiple ranges are wrapped in
+// a multipart/byteranges body. The content is read straight from the file, without buffering it
+func serveRanges(w *bufio.Writer, conn net.Conn, req *http.Request, f staticFile, stat os.FileInfo, ranges []byteRange, headers map[string]string, keepAlive bool) (Response, bool) {
+	var response Response
+	contentType := headers[HEADER_CONTENT_TYPE]
+	setConnectionHeaders(headers, req, keepAlive)
+
+	if len(ranges) == 1 {
+		headers[HEADER_CONTENT_RANGE] = ranges[0].contentRange(stat.Size())
+		headers[HEADER_CONTENT_LENGTH] = strconv.FormatInt(ranges[0].length, 10)
+		response = ResponsePartialContent(headers)
+		if _, err := w.Write(response.ResponseToByteNoBody()); err != nil {
+			logChannel.error("Error writing response", err)
+			return response, false
+		}
+		if _, err := sendFile(w, conn, f, ranges[0].start, ranges[0].length); err != nil {
+			logChannel.error("Error writing response", err)
+			return response, false
+		}
+		return response, true
+	}
+
+	// The length of the multipart body is computed upfront by writing the part headers only
+	var counter byteCounter
+	mw := multipart.NewWriter(&counter)
+	for _, r := range ranges {
+		if _, err := mw.CreatePart(rangePartHeader(r, contentType, stat.Size())); err != nil {
+			logChannel.error("Error writing multipart headers", err)
+			response = ResponseInternalServerError()
+			return response, writeResponse(w, req, &response, keepAlive)
+		}
+	}
+	mw.Close()
+	boundary := mw.Boundary()
+
+	headers[HEADER_CONTENT_TYPE] = "multipart/byteranges; boundary=" + boundary
+	headers[HEADER_CONTENT_LENGTH] = strconv.FormatInt(int64(counter)+rangesLength(ranges), 10)
+	response = ResponsePartialContent(headers)
+	if _, err := w.Write(response.ResponseToByteNoBody()); err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+
+	mw = multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+	for _, r := range ranges {
+		part, err := mw.CreatePart(rangePartHeader(r, contentType, stat.Size()))
+		if err == nil {
+			_, err = io.Copy(part, io.NewSectionReader(f, r.start, r.length))
+		}
+		if err != nil {
+			logChannel.error("Error writing response", err)
+			return response, false
+		}
+	}
+	if err := mw.Close(); err != nil {
+		logChannel.error("Error writing response", err)
+		return response, false
+	}
+	return response, true
+}
+
+func rangePartHeader(r byteRange, contentType string, size int64) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{}
+	header.Set(HEADER_CONTENT_RANGE, r.contentRange(size))
+	if contentType != "" {
+		header.Set(HEADER_CONTENT_TYPE, contentType)
+	}
+	return header
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		ranges []byteRange
+		err    error
+	}{
+		{"bytes=0-9", 100, []byteRange{{0, 10}}, nil},
+		{"bytes=10-", 100, []byteRange{{10, 90}}, nil},
+		{"bytes=90-200", 100, []byteRange{{90, 10}}, nil},
+		{"bytes=99-99", 100, []byteRange{{99, 1}}, nil},
+		{"bytes= 0 - 9 , 20-29", 100, []byteRange{{0, 10}, {20, 10}}, nil},
+		// Suffix ranges
+		{"bytes=-10", 100, []byteRange{{90, 10}}, nil},
+		{"bytes=-200", 100, []byteRange{{0, 100}}, nil},
+		{"bytes=-0", 100, nil, errUnsatisfiableRange},
+		// Overlapping ranges are served as requested
+		{"bytes=0-49,25-74", 100, []byteRange{{0, 50}, {25, 50}}, nil},
+		// start >= size
+		{"bytes=100-", 100, nil, errUnsatisfiableRange},
+		{"bytes=100-200", 100, nil, errUnsatisfiableRange},
+		{"bytes=0-", 0, nil, errUnsatisfiableRange},
+		{"bytes=200-300,0-9", 100, []byteRange{{0, 10}}, nil},
+		{"bytes=,0-9,", 100, []byteRange{{0, 10}}, nil},
+		// Invalid specs
+		{"", 100, nil, errInvalidRange},
+		{"items=0-9", 100, nil, errInvalidRange},
+		{"bytes=5", 100, nil, errInvalidRange},
+		{"bytes=9-0", 100, nil, errInvalidRange},
+		{"bytes=a-9", 100, nil, errInvalidRange},
+		{"bytes=0-b", 100, nil, errInvalidRange},
+		{"bytes=--5", 100, nil, errInvalidRange},
+		{"bytes=-5-", 100, nil, errInvalidRange},
+		{"bytes=0-9,x", 100, nil, errInvalidRange},
+	}
+	for _, test := range tests {
+		ranges, err := parseRange(test.header, test.size)
+		if err != test.err || !reflect.DeepEqual(ranges, test.ranges) {
+			t.Errorf("parseRange(%q, %d) = %v, %v, want %v, %v", test.header, test.size, ranges, err, test.ranges, test.err)
+		}
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	etag := `"v1"`
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 500, time.UTC)
+
+	tests := []struct {
+		ifRange string
+		valid   bool
+	}{
+		{"", true},
+		{`"v1"`, true},
+		{`"v0"`, false},
+		// If-Range requires a strong comparison
+		{`W/"v1"`, false},
+		{modTime.Format(http.TimeFormat), true},
+		{modTime.Add(time.Second).Format(http.TimeFormat), false},
+		{modTime.Add(-time.Second).Format(http.TimeFormat), false},
+		{"yesterday", false},
+	}
+	for _, test := range tests {
+		req := &http.Request{Header: http.Header{}}
+		if test.ifRange != "" {
+			req.Header.Set("If-Range", test.ifRange)
+		}
+		if valid := checkIfRange(req, etag, modTime); valid != test.valid {
+			t.Errorf("checkIfRange(%q) = %t, want %t", test.ifRange, valid, test.valid)
+		}
+	}
+}
+
+func TestRangeRequests(t *testing.T) {
+	conf := newTestConfig(t)
+	content := strings.Repeat("0123456789", 10)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"digits.txt": content})
+	addr := startTestServer(t)
+
+	full := fetch(t, addr, rawRequest("GET", "/digits.txt"))
+	if full.StatusCode != 200 || full.Header.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("got %d with Accept-Ranges %q, want 200 with bytes", full.StatusCode, full.Header.Get("Accept-Ranges"))
+	}
+	etag := full.Header.Get("ETag")
+
+	t.Run("single range", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("GET", "/digits.txt", "Range: bytes=10-19"))
+		if res.StatusCode != 206 || res.body != content[10:20] {
+			t.Fatalf("got %d %q, want 206 %q", res.StatusCode, res.body, content[10:20])
+		}
+		if got := res.Header.Get("Content-Range"); got != "bytes 10-19/100" {
+			t.Errorf("Content-Range %q, want bytes 10-19/100", got)
+		}
+		if res.ContentLength != 10 {
+			t.Errorf("Content-Length %d, want 10", res.ContentLength)
+		}
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("GET", "/digits.txt", "Range: bytes=0-4,-5"))
+		if res.StatusCode != 206 {
+			t.Fatalf("got %d, want 206", res.StatusCode)
+		}
+		if res.ContentLength != int64(len(res.body)) {
+			t.Errorf("Content-Length %d, but the body is %d bytes long", res.ContentLength, len(res.body))
+		}
+		mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf("Content-Type %q, want multipart/byteranges", res.Header.Get("Content-Type"))
+		}
+
+		want := []struct{ contentRange, body string }{{"bytes 0-4/100", content[:5]}, {"bytes 95-99/100", content[95:]}}
+		reader := multipart.NewReader(strings.NewReader(res.body), params["boundary"])
+		for i := 0; ; i++ {
+			part, err := reader.NextPart()
+			if err != nil {
+				if i != len(want) {
+					t.Errorf("got %d parts, want %d (%v)", i, len(want), err)
+				}
+				break
+			}
+			body, _ := ioutil.ReadAll(part)
+			if i >= len(want) {
+				t.Errorf("unexpected part %q", body)
+				continue
+			}
+			if got := part.Header.Get("Content-Range"); got != want[i].contentRange || string(body) != want[i].body {
+				t.Errorf("part %d: got %q %q, want %q %q", i, got, body, want[i].contentRange, want[i].body)
+			}
+			if got := part.Header.Get("Content-Type"); !strings.HasPrefix(got, "text/plain") {
+				t.Errorf("part %d: Content-Type %q, want text/plain", i, got)
+			}
+		}
+	})
+
+	t.Run("not satisfiable", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("GET", "/digits.txt", "Range: bytes=100-"))
+		if res.StatusCode != 416 {
+			t.Fatalf("got %d, want 416", res.StatusCode)
+		}
+		if got := res.Header.Get("Content-Range"); got != "bytes */100" {
+			t.Errorf("Content-Range %q, want bytes */100", got)
+		}
+		if res.ContentLength != int64(len(res.body)) {
+			t.Errorf("Content-Length %d, but the body is %d bytes long", res.ContentLength, len(res.body))
+		}
+	})
+
+	tests := []struct {
+		name    string
+		headers []string
+		status  int
+	}{
+		{"invalid range is ignored", []string{"Range: bytes=9-0"}, 200},
+		{"ranges longer than the file", []string{"Range: bytes=0-79,20-99"}, 200},
+		{"If-Range matching", []string{"Range: bytes=0-0", "If-Range: " + etag}, 206},
+		{"If-Range not matching", []string{"Range: bytes=0-0", `If-Range: "stale"`}, 200},
+		{"If-Range date not matching", []string{"Range: bytes=0-0", "If-Range: " + time.Unix(0, 0).UTC().Format(http.TimeFormat)}, 200},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := fetch(t, addr, rawRequest("GET", "/digits.txt", test.headers...))
+			if res.StatusCode != test.status {
+				t.Fatalf("got %d, want %d", res.StatusCode, test.status)
+			}
+			if test.status == 200 && res.body != content {
+				t.Errorf("got %q, want the whole file", res.body)
+			}
+			if test.status == 206 && res.Header.Get("Content-Length") != strconv.Itoa(1) {
+				t.Errorf("Content-Length %q, want 1", res.Header.Get("Content-Length"))
+			}
+		})
+	}
+
+	t.Run("HEAD ignores ranges", func(t *testing.T) {
+		res := fetch(t, addr, rawRequest("HEAD", "/digits.txt", "Range: bytes=0-9"))
+		if res.StatusCode != 200 || res.ContentLength != 100 || res.body != "" {
+			t.Errorf("got %d with Content-Length %d and body %q, want 200 with 100 and no body", res.StatusCode, res.ContentLength, res.body)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ACME_CHALLENGE_PREFIX path of ACME HTTP-01 challenges, which have to be answered over plain HTTP
+const ACME_CHALLENGE_PREFIX string = "/.well-known/acme-challenge/"
+
+// HSTS_PRELOAD_MIN_MAX_AGE minimum max-age (one year) accepted by HSTS preload lists
+const HSTS_PRELOAD_MIN_MAX_AGE = 31536000
+
+// strictTransportSecurity value of the Strict-Transport-Security header, empty when HSTS is disabled
+var strictTransportSecurity string
+
+// strictTransportSecurityValue Builds the Strict-Transport-Security header value out of the HSTS configuration
+func strictTransportSecurityValue(hsts HSTS) string {
+	if hsts.MaxAge <= 0 {
+		return ""
+	}
+	value := "max-age=" + strconv.Itoa(hsts.MaxAge)
+	if hsts.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if hsts.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
+// setConnectionHeaders Sets the headers depending on the connection rather than on the served content: Connection,
+// and Strict-Transport-Security for requests received over TLS
+func setConnectionHeaders(headers map[string]string, req *http.Request, keepAlive bool) {
+	headers[HEADER_CONNECTION] = connectionHeader(keepAlive)
+	if req.TLS != nil && strictTransportSecurity != "" {
+		headers[HEADER_STRICT_TRANSPORT_SECURITY] = strictTransportSecurity
+	}
+}
+
+// redirectToHTTPS Reports whether a request received over plain HTTP has to be redirected to HTTPS
+func redirectToHTTPS(req *http.Request) bool {
+	return req.TLS == nil && config.Minosse.TLS.Enabled && config.Minosse.TLS.RedirectHTTP && !strings.HasPrefix(req.URL.Path, ACME_CHALLENGE_PREFIX)
+}
+
+// ResponseHTTPSRedirect Redirects the request to the same path and query over HTTPS, on the TLS port. Requests without
+// a Host header cannot be redirected, and get a 400 instead
+func ResponseHTTPSRedirect(req *http.Request) Response {
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	} else {
+		// IPv6 literal without a port, bracketed again below
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+	if host == "" {
+		return ResponseBadRequest()
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+	if config.Minosse.TLS.Port != 443 {
+		host += ":" + strconv.Itoa(config.Minosse.TLS.Port)
+	}
+	location := "https://" + host + req.URL.RequestURI()
+	if config.Minosse.TLS.RedirectStatus == 308 {
+		return ResponsePermanentRedirect(location)
+	}
+	return ResponseMovedPermanently(location)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestResponseHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		host     string
+		target   string
+		port     int
+		status   int
+		location string
+	}{
+		{"default port", "example.com", "/docs/", 443, 301, "https://example.com/docs/"},
+		{"custom port", "example.com", "/docs/", 8443, 301, "https://example.com:8443/docs/"},
+		{"port of the plain listener dropped", "example.com:8080", "/", 443, 301, "https://example.com/"},
+		{"query preserved", "example.com", "/search?q=a%20b&page=2", 443, 301, "https://example.com/search?q=a%20b&page=2"},
+		{"escaped path preserved", "example.com", "/a%2Fb/c%20d", 443, 301, "https://example.com/a%2Fb/c%20d"},
+		{"IPv6", "[::1]:8080", "/", 443, 301, "https://[::1]/"},
+		{"IPv6 without a port", "[2001:db8::1]", "/", 443, 301, "https://[2001:db8::1]/"},
+		{"IPv6 on a custom port", "[2001:db8::1]", "/", 8443, 301, "https://[2001:db8::1]:8443/"},
+		{"IPv4", "127.0.0.1:8080", "/", 8443, 301, "https://127.0.0.1:8443/"},
+		{"308", "example.com", "/upload", 443, 308, "https://example.com/upload"},
+		{"missing Host", "", "/", 443, 400, ""},
+	}
+	for _, test := range tests {
+		conf := newTestConfig(t)
+		conf.Minosse.TLS = TLS{Port: test.port, RedirectStatus: 301}
+		if test.status == 308 {
+			conf.Minosse.TLS.RedirectStatus = 308
+		}
+		u, err := url.ParseRequestURI(test.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := ResponseHTTPSRedirect(&http.Request{Host: test.host, URL: u})
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(response.ToByte())), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != test.status || res.Header.Get("Location") != test.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", test.name, res.StatusCode, res.Header.Get("Location"), test.status, test.location)
+		}
+	}
+}
+
+func TestStrictTransportSecurityValue(t *testing.T) {
+	tests := []struct {
+		hsts  HSTS
+		value string
+	}{
+		{HSTS{}, ""},
+		{HSTS{IncludeSubDomains: true, Preload: true}, ""},
+		{HSTS{MaxAge: 600}, "max-age=600"},
+		{HSTS{MaxAge: 600, IncludeSubDomains: true}, "max-age=600; includeSubDomains"},
+		{HSTS{MaxAge: HSTS_PRELOAD_MIN_MAX_AGE, IncludeSubDomains: true, Preload: true}, "max-age=31536000; includeSubDomains; preload"},
+	}
+	for _, test := range tests {
+		if value := strictTransportSecurityValue(test.hsts); value != test.value {
+			t.Errorf("%+v: got %q, want %q", test.hsts, value, test.value)
+		}
+	}
+}
+
+// newRedirectTestConfig Enables TLS with HTTPS redirects and HSTS, along with a key pair for the TLS listener
+func newRedirectTestConfig(t *testing.T) (*Config, tls.Certificate) {
+	conf := newTestConfig(t)
+	previous := strictTransportSecurity
+	t.Cleanup(func() { strictTransportSecurity = previous })
+	pair := writeTestCertificate(t, t.TempDir(), "server", newTestECDSAKey(t), "example.com")
+	conf.Minosse.TLS = TLS{Enabled: true, Port: 8443, X509CertPath: pair.X509CertPath, X509KeyPath: pair.X509KeyPath, RedirectHTTP: true, HSTS: HSTS{MaxAge: 600}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"index.html": "home", ".well-known/acme-challenge/token": "key authorization"})
+	cert, err := tls.LoadX509KeyPair(pair.X509CertPath, pair.X509KeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conf, cert
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	newRedirectTestConfig(t)
+	addr := startTestServer(t)
+
+	responses := roundTrip(t, addr,
+		hostRequest("/index.html?lang=en", "example.com:8080"),
+		rawRequest("HEAD", "/missing"),
+		hostRequest("/.well-known/acme-challenge/token", "example.com"),
+	)
+	if res := responses[0]; res.StatusCode != 301 || res.Header.Get("Location") != "https://example.com:8443/index.html?lang=en" {
+		t.Errorf("got %d to %q, want 301 to the same URL over HTTPS", res.StatusCode, res.Header.Get("Location"))
+	}
+	if res := responses[1]; res.StatusCode != 301 || res.Header.Get("Location") != "https://localhost:8443/missing" {
+		t.Errorf("HEAD: got %d to %q, want 301", res.StatusCode, res.Header.Get("Location"))
+	}
+	// ACME HTTP-01 challenges are answered over plain HTTP
+	if res := responses[2]; res.StatusCode != 200 || res.body != "key authorization" {
+		t.Errorf("ACME challenge: got %d %q, want 200 with the key authorization", res.StatusCode, res.body)
+	}
+	// HSTS is only sent over TLS, browsers ignore it otherwise
+	for _, res := range responses {
+		if res.Header.Get("Strict-Transport-Security") != "" {
+			t.Errorf("HSTS sent over plain HTTP")
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	_, cert := newRedirectTestConfig(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTestListener(t, tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}}))
+
+	// Requests over TLS are never redirected, and every response carries the header
+	for _, target := range []string{"/", "/index.html", "/missing"} {
+		res := fetchTLS(t, addr, nil, rawRequest("GET", target))
+		if res.StatusCode == 301 || res.Header.Get("Strict-Transport-Security") != "max-age=600" {
+			t.Errorf("%s: got %d with HSTS %q, want max-age=600", target, res.StatusCode, res.Header.Get("Strict-Transport-Security"))
+		}
+	}
+}
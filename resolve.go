@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var errInvalidPath = errors.New("invalid request path")
+var errOutsideWebRoot = errors.New("request path resolves outside of the web root")
+var errSymlinkNotAllowed = errors.New("request path goes through a symlink not allowed by the symlink policy")
+
+// Symlink policies
+const (
+	// SYMLINKS_FOLLOW always follows symlinks, wherever they point to
+	SYMLINKS_FOLLOW string = "follow"
+	// SYMLINKS_DENY refuses any path with a symlink among its components
+	SYMLINKS_DENY string = "deny"
+	// SYMLINKS_OWNER_MATCH follows symlinks pointing inside the web root, or owned by the same user as their target
+	SYMLINKS_OWNER_MATCH string = "ownerMatch"
+)
+
+// pathResolver Maps request URLs to files inside a web root
+type pathResolver struct {
+	// root absolute path of the web root
+	root string
+	// realRoot root with every symlink resolved
+	realRoot string
+	// symlinks policy applied to symlinks found below root
+	symlinks string
+}
+
+func newPathResolver(webRoot string, symlinks string) (*pathResolver, error) {
+	root, err := filepath.Abs(webRoot)
+	if err != nil {
+		return nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	return &pathResolver{root: root, realRoot: realRoot, symlinks: symlinks}, nil
+}
+
+// validate Rejects, with errInvalidPath, URLs whose path cannot be safely mapped to a file: NUL bytes, encoded
+// slashes and backslashes. Only the (percent-decoded) path of the URL is considered, never its query string
+func (r *pathResolver) validate(u *url.URL) error {
+	urlPath := u.Path
+	if urlPath == "" || urlPath[0] != '/' || strings.IndexByte(urlPath, 0) >= 0 || strings.IndexByte(urlPath, '\\') >= 0 {
+		return errInvalidPath
+	}
+	// RawPath is only kept when the original encoding differs from the default one, which is the case of %2F and %5C
+	rawPath := strings.ToLower(u.RawPath)
+	if strings.Contains(rawPath, "%2f") || strings.Contains(rawPath, "%5c") {
+		return errInvalidPath
+	}
+	return nil
+}
+
+// resolvePath Maps an already validated and decoded URL path to a file inside the web root. Paths escaping the web
+// root are rejected with errOutsideWebRoot, while symlinks are subject to the configured policy and rejected with
+// errSymlinkNotAllowed
+func (r *pathResolver) resolvePath(urlPath string) (string, error) {
+	if urlPath == "" || urlPath[0] != '/' || strings.IndexByte(urlPath, 0) >= 0 {
+		return "", errInvalidPath
+	}
+	// Cleaning a rooted path never leaves the root, whatever the amount of ".." segments
+	full := filepath.Join(r.root, filepath.FromSlash(path.Clean(urlPath)))
+	if !isInside(r.root, full) {
+		return "", errOutsideWebRoot
+	}
+
+	if err := r.checkSymlinks(full); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// checkSymlinks Walks every component of full below the web root, applying the symlink policy to each symlink
+func (r *pathResolver) checkSymlinks(full string) error {
+	current := r.root
+	for _, component := range strings.Split(strings.TrimPrefix(full, r.root), string(os.PathSeparator)) {
+		if component == "" {
+			continue
+		}
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		switch r.symlinks {
+		case SYMLINKS_FOLLOW:
+		case SYMLINKS_DENY:
+			return errSymlinkNotAllowed
+		default:
+			target, err := filepath.EvalSymlinks(current)
+			if err != nil {
+				return err
+			}
+			if isInside(r.realRoot, target) {
+				continue
+			}
+			targetInfo, err := os.Stat(target)
+			if err != nil {
+				return err
+			}
+			linkOwner, ok := fileOwner(info)
+			targetOwner, targetOk := fileOwner(targetInfo)
+			if !ok || !targetOk || linkOwner != targetOwner {
+				return errSymlinkNotAllowed
+			}
+		}
+	}
+	return nil
+}
+
+// isInside Reports whether the (clean, absolute) path p is root itself or one of its descendants
+func isInside(root, p string) bool {
+	return p == root || strings.HasPrefix(p, strings.TrimSuffix(root, string(os.PathSeparator))+string(os.PathSeparator))
+}
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// FuzzResolvePath Checks that no request URI resolves outside of the web root, symlinks included. Fuzzing needs Go
+// 1.18 or later, hence the build constraint: run it with "go test -fuzz FuzzResolvePath"
+func FuzzResolvePath(f *testing.F) {
+	for _, seed := range []string{"/index.html", "/my%20dir/my%20file.txt", "/../etc/passwd", "/%2e%2e/etc/passwd", "/..%2fetc", "/..%5cetc", "//etc", "/a%00", "/escape/passwd", "/inner/../escape/passwd"} {
+		f.Add(seed)
+	}
+	r, _ := newTestResolver(f, SYMLINKS_DENY)
+
+	f.Fuzz(func(t *testing.T, requestURI string) {
+		full, err := resolveRequestURI(r, requestURI)
+		if err != nil {
+			return
+		}
+		if !isInside(r.root, full) {
+			t.Fatalf("%q resolved outside of the web root: %s", requestURI, full)
+		}
+		// Whatever the path went through, the file it designates is inside the web root
+		if real, err := filepath.EvalSymlinks(full); err == nil && !isInside(r.realRoot, real) {
+			t.Fatalf("%q resolved outside of the web root through a symlink: %s", requestURI, real)
+		}
+	})
+}
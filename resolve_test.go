@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestResolver Prepares a web root holding a few files (including a decoy etc/passwd), a symlink pointing inside
+// it ("inner") and one pointing to a directory outside of it ("escape"), returning its resolver along with the
+// outside directory
+func newTestResolver(t testing.TB, symlinks string) (*pathResolver, string) {
+	t.Helper()
+	root, outside := t.TempDir(), t.TempDir()
+	for _, dir := range []string{"docs", "etc", "my dir"} {
+		if err := os.Mkdir(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{filepath.Join(root, "index.html"), filepath.Join(root, "etc", "passwd"), filepath.Join(root, "my dir", "my file.txt"), filepath.Join(outside, "passwd")} {
+		if err := ioutil.WriteFile(file, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "docs"), filepath.Join(root, "inner")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	r, err := newPathResolver(root, symlinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, outside
+}
+
+// resolveRequestURI Goes through validate and resolvePath, parsing requestURI the same way http.ReadRequest does
+func resolveRequestURI(r *pathResolver, requestURI string) (string, error) {
+	u, err := url.ParseRequestURI(requestURI)
+	if err != nil {
+		return "", errInvalidPath
+	}
+	if err := r.validate(u); err != nil {
+		return "", err
+	}
+	return r.resolvePath(u.Path)
+}
+
+func TestResolveTraversalPayloads(t *testing.T) {
+	r, _ := newTestResolver(t, SYMLINKS_DENY)
+
+	tests := []struct {
+		requestURI string
+		// file expected relative to the web root, when err is nil
+		file string
+		err  error
+	}{
+		{"/index.html", "index.html", nil},
+		{"/index.html?v=2", "index.html", nil},
+		{"/index.html?file=../../etc/passwd", "index.html", nil},
+		{"/my%20dir/my%20file.txt", "my dir/my file.txt", nil},
+		{"/../../../../etc/passwd", "etc/passwd", nil},
+		{"/%2e%2e/%2e%2e/etc/passwd", "etc/passwd", nil},
+		{"/docs/../../index.html", "index.html", nil},
+		{"//etc/passwd", "etc/passwd", nil},
+		{"/.%2e/.%2e/etc/passwd", "etc/passwd", nil},
+		{"/..%2f..%2fetc/passwd", "", errInvalidPath},
+		{"/..%2F..%2Fetc/passwd", "", errInvalidPath},
+		{"/docs%2findex.html", "", errInvalidPath},
+		{"/..%5c..%5cetc/passwd", "", errInvalidPath},
+		{"/..\\..\\etc\\passwd", "", errInvalidPath},
+		{"/index.html%00.txt", "", errInvalidPath},
+		{"/%00", "", errInvalidPath},
+		{"/escape/passwd", "", errSymlinkNotAllowed},
+		{"/inner/../escape/passwd", "", errSymlinkNotAllowed},
+		{"/inner", "", errSymlinkNotAllowed},
+	}
+	for _, test := range tests {
+		full, err := resolveRequestURI(r, test.requestURI)
+		if test.err != nil {
+			if err != test.err {
+				t.Errorf("%s: got error %v, want %v", test.requestURI, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.requestURI, err)
+			continue
+		}
+		if want := filepath.Join(r.root, filepath.FromSlash(test.file)); full != want {
+			t.Errorf("%s: resolved to %s, want %s", test.requestURI, full, want)
+		}
+	}
+}
+
+func TestResolveResponses(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Symlinks = SYMLINKS_DENY
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"index.html": "index"})
+	outside := t.TempDir()
+	writeTestFiles(t, outside, map[string]string{"secret.txt": "secret"})
+	if err := os.Symlink(outside, filepath.Join(conf.Minosse.WebRoot, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestServer(t)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/index.html", 200},
+		{"/../index.html", 200},
+		{"/..%2findex.html", 400},
+		{"/index.html%00", 400},
+		{"/escape/secret.txt", 404},
+		{"/missing.html", 404},
+	}
+	for _, test := range tests {
+		if res := fetch(t, addr, rawRequest("GET", test.target)); res.StatusCode != test.status {
+			t.Errorf("%s: got status %d, want %d", test.target, res.StatusCode, test.status)
+		}
+	}
+}
+
+func TestResolveSymlinkPolicies(t *testing.T) {
+	tests := []struct {
+		symlinks string
+		path     string
+		err      error
+	}{
+		{SYMLINKS_FOLLOW, "/escape/passwd", nil},
+		{SYMLINKS_FOLLOW, "/inner", nil},
+		{SYMLINKS_DENY, "/inner", errSymlinkNotAllowed},
+		{SYMLINKS_DENY, "/index.html", nil},
+		{SYMLINKS_OWNER_MATCH, "/inner", nil},
+		// The link and its target have the same owner
+		{SYMLINKS_OWNER_MATCH, "/escape/passwd", nil},
+	}
+	for _, test := range tests {
+		r, _ := newTestResolver(t, test.symlinks)
+		if _, err := r.resolvePath(test.path); err != test.err {
+			t.Errorf("%s %s: got error %v, want %v", test.symlinks, test.path, err, test.err)
+		}
+	}
+}
+
+func TestResolveOwnerMismatch(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of the symlink target requires root")
+	}
+	r, outside := newTestResolver(t, SYMLINKS_OWNER_MATCH)
+	if err := os.Chown(outside, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.resolvePath("/escape/passwd"); err != errSymlinkNotAllowed {
+		t.Errorf("got error %v, want %v", err, errSymlinkNotAllowed)
+	}
+	// Symlinks pointing inside the web root are followed whatever their owner
+	if _, err := r.resolvePath("/inner"); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+}
+
+func TestDefaultSymlinkPolicy(t *testing.T) {
+	conf := newTestConfig(t)
+	applyDefaultConfigValues(conf)
+	if defaultHost.Symlinks != SYMLINKS_FOLLOW {
+		t.Errorf("got default symlink policy %q, want %q", defaultHost.Symlinks, SYMLINKS_FOLLOW)
+	}
+}
@@ -16,11 +16,21 @@ type Response struct {
 
 func ResponseMethodNotAllowed() Response {
 	return Response{
-		status:     HTTP_NOT_FOUND,
+		status:     HTTP_NOT_ALLOWED,
 		statusCode: 405,
 		protocol:   HTTP_1_1,
-		body:       []byte(HTTP_NOT_FOUND_BODY),
-		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8"}, HeaderMapToString),
+		body:       []byte(HTTP_NOT_ALLOWED_BODY),
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_NOT_ALLOWED_BODY)), HEADER_ALLOW: HEADER_ALLOW_VALUE}, HeaderMapToString),
+	}
+}
+
+// ResponseOptions Response to an OPTIONS request, advertising the supported methods
+func ResponseOptions() Response {
+	return Response{
+		status:     HTTP_OK,
+		statusCode: 200,
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_LENGTH: "0", HEADER_ALLOW: HEADER_ALLOW_VALUE, HEADER_SERVER: HEADER_SERVER_VALUE}, HeaderMapToString),
 	}
 }
 
@@ -30,7 +40,47 @@ func ResponseInternalServerError() Response {
 		statusCode: 500,
 		body:       []byte(HTTP_INTERNAL_SERVER_ERROR),
 		protocol:   HTTP_1_1,
-		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8"}, HeaderMapToString),
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_INTERNAL_SERVER_ERROR))}, HeaderMapToString),
+	}
+}
+
+func ResponseBadRequest() Response {
+	return Response{
+		status:     HTTP_BAD_REQUEST,
+		statusCode: 400,
+		body:       []byte(HTTP_BAD_REQUEST_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_BAD_REQUEST_BODY))}, HeaderMapToString),
+	}
+}
+
+func ResponseForbidden() Response {
+	return Response{
+		status:     HTTP_FORBIDDEN,
+		statusCode: 403,
+		body:       []byte(HTTP_FORBIDDEN_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_FORBIDDEN_BODY))}, HeaderMapToString),
+	}
+}
+
+func ResponseMovedPermanently(location string) Response {
+	return Response{
+		status:     HTTP_MOVED_PERMANENTLY,
+		statusCode: 301,
+		body:       []byte(HTTP_MOVED_PERMANENTLY_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_MOVED_PERMANENTLY_BODY)), HEADER_LOCATION: location}, HeaderMapToString),
+	}
+}
+
+func ResponsePermanentRedirect(location string) Response {
+	return Response{
+		status:     HTTP_PERMANENT_REDIRECT,
+		statusCode: 308,
+		body:       []byte(HTTP_PERMANENT_REDIRECT_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_PERMANENT_REDIRECT_BODY)), HEADER_LOCATION: location}, HeaderMapToString),
 	}
 }
 
@@ -40,7 +90,61 @@ func ResponseNotFound() Response {
 		statusCode: 404,
 		body:       []byte(HTTP_NOT_FOUND_BODY),
 		protocol:   HTTP_1_1,
-		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8"}, HeaderMapToString),
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_NOT_FOUND_BODY))}, HeaderMapToString),
+	}
+}
+
+// ResponseNotModified Response to a conditional request whose representation did not change. headers should only
+// contain the validators and caching headers that a 200 response would have sent
+func ResponseNotModified(headers map[string]string) Response {
+	return Response{
+		status:     HTTP_NOT_MODIFIED,
+		statusCode: 304,
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(headers, HeaderMapToString),
+	}
+}
+
+func ResponsePreconditionFailed() Response {
+	return Response{
+		status:     HTTP_PRECONDITION_FAILED,
+		statusCode: 412,
+		body:       []byte(HTTP_PRECONDITION_FAILED_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_PRECONDITION_FAILED_BODY))}, HeaderMapToString),
+	}
+}
+
+// ResponseNotAcceptable Response to a request whose Accept-Encoding refuses every available encoding, identity included
+func ResponseNotAcceptable(headers map[string]string) Response {
+	headers[HEADER_CONTENT_TYPE] = "text/plain; charset=utf-8"
+	headers[HEADER_CONTENT_LENGTH] = strconv.Itoa(len(HTTP_NOT_ACCEPTABLE_BODY))
+	return Response{
+		status:     HTTP_NOT_ACCEPTABLE,
+		statusCode: 406,
+		body:       []byte(HTTP_NOT_ACCEPTABLE_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(headers, HeaderMapToString),
+	}
+}
+
+func ResponsePartialContent(headers map[string]string) Response {
+	return Response{
+		status:     HTTP_PARTIAL_CONTENT,
+		statusCode: 206,
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(headers, HeaderMapToString),
+	}
+}
+
+// ResponseRangeNotSatisfiable Response to a byte range request that does not overlap the current size of the file
+func ResponseRangeNotSatisfiable(size int64) Response {
+	return Response{
+		status:     HTTP_RANGE_NOT_SATISFIABLE,
+		statusCode: 416,
+		body:       []byte(HTTP_RANGE_NOT_SATISFIABLE_BODY),
+		protocol:   HTTP_1_1,
+		headers:    HashmapMapToString(map[string]string{HEADER_CONTENT_TYPE: "text/plain; charset=utf-8", HEADER_CONTENT_LENGTH: strconv.Itoa(len(HTTP_RANGE_NOT_SATISFIABLE_BODY)), HEADER_CONTENT_RANGE: RANGE_UNIT_BYTES + " */" + strconv.FormatInt(size, 10)}, HeaderMapToString),
 	}
 }
 
@@ -130,12 +234,28 @@ func (r *Response) StatusCode(statusCode int) *Response {
 	return r
 }
 
-/*
+// Header Appends a single header to the Response. Can be called multiple times for different headers.
 func (r *Response) Header(header, value string) *Response {
-		r.headers[header] = value
-		return r
+	r.headers += HeaderMapToString(header, value)
+	return r
+}
+
+// SetBody Replaces the body of the Response, updating its Content-Type and Content-Length headers accordingly
+func (r *Response) SetBody(body []byte, contentType string) *Response {
+	var headers strings.Builder
+	for _, line := range strings.SplitAfter(r.headers, EOL) {
+		if line == "" || strings.HasPrefix(line, HEADER_CONTENT_TYPE+":") || strings.HasPrefix(line, HEADER_CONTENT_LENGTH+":") {
+			continue
+		}
+		headers.WriteString(line)
+	}
+	r.headers = headers.String()
+	r.body = body
+	if contentType != "" {
+		r.Header(HEADER_CONTENT_TYPE, contentType)
+	}
+	return r.Header(HEADER_CONTENT_LENGTH, strconv.Itoa(len(body)))
 }
-*/
 
 func (r *Response) Headers(headers map[string]string) *Response {
 	r.headers = HashmapMapToString(headers, HeaderMapToString)
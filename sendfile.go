@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// copyFile Copies length bytes of f, starting at offset, through the buffered writer of the connection. Files on disk
+// are handed over as an io.LimitedReader wrapping the *os.File: once the buffer is flushed, bufio passes the reader on
+// to the connection, whose ReadFrom only takes the runtime zero-copy path (e.g. sendfile(2) over TCP) for those
+func copyFile(w *bufio.Writer, f staticFile, offset, length int64) (int64, error) {
+	if file, ok := f.(*os.File); ok {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return io.Copy(w, &io.LimitedReader{R: file, N: length})
+	}
+	return io.Copy(w, io.NewSectionReader(f, offset, length))
+}
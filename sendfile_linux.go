@@ -0,0 +1,75 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendFile Sends length bytes of f, starting at offset, over conn. Files on disk sent over plain TCP connections go
+// through sendfile(2), so that their content never reaches userspace. Everything else (TLS connections, files held
+// by the file cache, small bodies) is copied through the buffered writer
+func sendFile(w *bufio.Writer, conn net.Conn, f staticFile, offset, length int64) (int64, error) {
+	file, isFile := f.(*os.File)
+	tcpConn, isTCP := conn.(*net.TCPConn)
+	if !config.Minosse.Sendfile.Enabled || !isFile || !isTCP || length < SendfileMinSize {
+		return copyFile(w, f, offset, length)
+	}
+
+	// Whatever is buffered (e.g. the response headers) has to leave before the file content
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	rawFile, err := file.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var written int64
+	var sendErr error
+	err = rawFile.Control(func(infd uintptr) {
+		err := rawConn.Write(func(outfd uintptr) bool {
+			for written < length {
+				chunk := length - written
+				if chunk > config.Minosse.Sendfile.ChunkSize {
+					chunk = config.Minosse.Sendfile.ChunkSize
+				}
+				position := offset + written
+				n, err := syscall.Sendfile(int(outfd), int(infd), &position, int(chunk))
+				if n > 0 {
+					written += int64(n)
+				}
+				switch {
+				case err == syscall.EAGAIN:
+					// Socket buffer full: wait for the poller to report the connection as writable again
+					return false
+				case err == syscall.EINTR:
+				case err != nil:
+					sendErr = os.NewSyscallError("sendfile", err)
+					return true
+				case n == 0:
+					// The file was truncated while sending it
+					sendErr = io.ErrUnexpectedEOF
+					return true
+				}
+			}
+			return true
+		})
+		if sendErr == nil {
+			sendErr = err
+		}
+	})
+	if err != nil {
+		return written, err
+	}
+	return written, sendErr
+}
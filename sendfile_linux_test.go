@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSendFile(t *testing.T) {
+	content := make([]byte, 3*SendfileMinSize+123)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	for _, enabled := range []bool{true, false} {
+		t.Run("enabled="+strconv.FormatBool(enabled), func(t *testing.T) {
+			conf := newTestConfig(t)
+			// Small chunks make sendfile(2) loop, and resume after a full socket buffer
+			conf.Minosse.Sendfile = Sendfile{Enabled: enabled, ChunkSize: 4096}
+			writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"random.bin": string(content), "small.txt": "small"})
+			addr := startTestServer(t)
+
+			responses := roundTrip(t, addr,
+				rawRequest("GET", "/random.bin"),
+				rawRequest("GET", "/random.bin", "Range: bytes=1000-40000"),
+				rawRequest("GET", "/small.txt"),
+			)
+			if responses[0].body != string(content) {
+				t.Errorf("got %d bytes, want the whole file of %d", len(responses[0].body), len(content))
+			}
+			if responses[1].StatusCode != 206 || responses[1].body != string(content[1000:40001]) {
+				t.Errorf("got %d with %d bytes, want 206 with bytes 1000-40000", responses[1].StatusCode, len(responses[1].body))
+			}
+			if responses[2].body != "small" {
+				t.Errorf("got %q after the file, want small", responses[2].body)
+			}
+		})
+	}
+}
+
+var benchmarkFileSizes = []int64{1 << 10, 8 << 10, 64 << 10, 1 << 20, 8 << 20}
+
+// benchmarkFileTransfer Measures send, which writes a whole file on a loopback TCP connection, for each file size. The
+// other end of the connection discards everything it reads
+func benchmarkFileTransfer(b *testing.B, send func(w *bufio.Writer, conn net.Conn, f *os.File, size int64) (int64, error)) {
+	config.Minosse.Sendfile = Sendfile{Enabled: true, ChunkSize: SendfileChunkSize}
+	for _, size := range benchmarkFileSizes {
+		b.Run(strconv.FormatInt(size>>10, 10)+"KB", func(b *testing.B) {
+			pathFile := filepath.Join(b.TempDir(), "file")
+			if err := ioutil.WriteFile(pathFile, make([]byte, size), 0644); err != nil {
+				b.Fatal(err)
+			}
+			f, err := os.Open(pathFile)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer f.Close()
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				io.Copy(ioutil.Discard, conn)
+			}()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			w := bufio.NewWriter(conn)
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if n, err := send(w, conn, f, size); err != nil || n != size {
+					b.Fatalf("sent %d bytes out of %d: %v", n, size, err)
+				}
+				if err := w.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSendFile(b *testing.B) {
+	benchmarkFileTransfer(b, func(w *bufio.Writer, conn net.Conn, f *os.File, size int64) (int64, error) {
+		return sendFile(w, conn, f, 0, size)
+	})
+}
+
+func BenchmarkCopyFile(b *testing.B) {
+	benchmarkFileTransfer(b, func(w *bufio.Writer, conn net.Conn, f *os.File, size int64) (int64, error) {
+		return copyFile(w, f, 0, size)
+	})
+}
+
+// BenchmarkIOCopy The baseline: the file copied straight to the connection, which lets the runtime pick its own
+// zero-copy path
+func BenchmarkIOCopy(b *testing.B) {
+	benchmarkFileTransfer(b, func(w *bufio.Writer, conn net.Conn, f *os.File, size int64) (int64, error) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return io.Copy(conn, f)
+	})
+}
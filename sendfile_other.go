@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"bufio"
+	"net"
+)
+
+// sendFile Sends length bytes of f, starting at offset, over conn. sendfile(2) is only used on Linux, other platforms
+// always copy through the buffered writer
+func sendFile(w *bufio.Writer, conn net.Conn, f staticFile, offset, length int64) (int64, error) {
+	return copyFile(w, f, offset, length)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	pathFile := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(pathFile, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(pathFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	cached := &cachedFile{Reader: bytes.NewReader(content)}
+
+	tests := []struct {
+		name           string
+		f              staticFile
+		offset, length int64
+	}{
+		{"whole file", f, 0, int64(len(content))},
+		{"range past the write buffer", f, 1000, 20000},
+		{"range within the write buffer", f, 17, 100},
+		{"cached file", cached, 1000, 20000},
+	}
+	for _, test := range tests {
+		// Over TCP, so that the connection offers the zero-copy ReadFrom
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := make(chan []byte)
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				close(received)
+				return
+			}
+			defer conn.Close()
+			data, _ := ioutil.ReadAll(conn)
+			received <- data
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The headers buffered before the body leave first
+		w := bufio.NewWriter(conn)
+		w.WriteString("headers\r\n\r\n")
+		n, err := copyFile(w, test.f, test.offset, test.length)
+		if err == nil {
+			err = w.Flush()
+		}
+		conn.Close()
+		data := <-received
+		listener.Close()
+		want := "headers\r\n\r\n" + string(content[test.offset:test.offset+test.length])
+		if err != nil || n != test.length || string(data) != want {
+			t.Errorf("%s: copied %d bytes (%v), received %d, want %d", test.name, n, err, len(data), len(want))
+		}
+	}
+
+	// Copies do not depend on the offset left by a previous one
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	w := bufio.NewWriter(&out)
+	if _, err := copyFile(w, f, 16, 16); err != nil || w.Flush() != nil || out.String() != "0123456789abcdef" {
+		t.Errorf("got %q (%v), want the second 16 bytes", out.String(), err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httputil"
+)
+
+// bodyStream The body of a response whose length is not known in advance. It is sent with chunked transfer encoding
+// to HTTP/1.1 clients, and delimited by closing the connection for HTTP/1.0 ones
+type bodyStream struct {
+	w       *bufio.Writer
+	chunked io.WriteCloser
+}
+
+// startStream Writes the headers of a 200 response with a streamed body. The returned keepAlive takes into account
+// that the connection has to be closed when chunked encoding is not available
+func startStream(w *bufio.Writer, req *http.Request, headers map[string]string, keepAlive bool) (Response, *bodyStream, bool, error) {
+	chunked := req.ProtoAtLeast(1, 1)
+	keepAlive = keepAlive && chunked
+	setConnectionHeaders(headers, req, keepAlive)
+	if chunked {
+		headers[HEADER_TRANSFER_ENCODING] = TRANSFER_ENCODING_CHUNKED
+	}
+
+	response := ResponseOkNoBody(headers)
+	if _, err := w.Write(response.ResponseToByteNoBody()); err != nil {
+		return response, nil, false, err
+	}
+
+	stream := &bodyStream{w: w}
+	if chunked {
+		stream.chunked = httputil.NewChunkedWriter(w)
+	}
+	return response, stream, keepAlive, nil
+}
+
+func (s *bodyStream) Write(p []byte) (int, error) {
+	if s.chunked != nil {
+		return s.chunked.Write(p)
+	}
+	return s.w.Write(p)
+}
+
+// Close Terminates the body: with chunked encoding, this means the last chunk followed by an empty trailer
+func (s *bodyStream) Close() error {
+	if s.chunked == nil {
+		return nil
+	}
+	if err := s.chunked.Close(); err != nil {
+		return err
+	}
+	_, err := s.w.WriteString("\r\n")
+	return err
+}
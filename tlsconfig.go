@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/acme"
+)
+
+// SESSION_TICKET_KEY_SIZE size in bytes of a single session ticket key
+const SESSION_TICKET_KEY_SIZE = 32
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCurves = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+var errSessionTicketKeys = errors.New("session ticket key file must contain one or more keys of 32 bytes each")
+
+// cipherSuite Looks up a cipher suite by its IANA name (e.g. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), reporting whether
+// it is considered insecure
+func cipherSuite(name string) (*tls.CipherSuite, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite, false
+		}
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return suite, true
+		}
+	}
+	return nil, false
+}
+
+// validateTLSParameters Checks versions, cipher suites, curves and ALPN protocols, returning a description of the first
+// invalid one
+func validateTLSParameters(conf *TLS) string {
+	if _, ok := tlsVersions[conf.MinVersion]; !ok {
+		return "The specified minimum TLS version is not valid. Possible values are: 1.0 | 1.1 | 1.2 | 1.3"
+	}
+	if _, ok := tlsVersions[conf.MaxVersion]; !ok && conf.MaxVersion != "" {
+		return "The specified maximum TLS version is not valid. Possible values are: 1.0 | 1.1 | 1.2 | 1.3"
+	}
+	if conf.MaxVersion != "" && tlsVersions[conf.MaxVersion] < tlsVersions[conf.MinVersion] {
+		return "The specified maximum TLS version is lower than the minimum one"
+	}
+	for _, name := range conf.CipherSuites {
+		suite, insecure := cipherSuite(name)
+		if suite == nil {
+			return "The specified cipher suite is not supported: " + name
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return "TLS 1.3 cipher suites are not configurable: " + name
+		}
+		if insecure {
+			logChannel.channel <- Log{level: WARNING, message: "The specified cipher suite is insecure", data: []zap.Field{zap.String("cipherSuite", name)}}
+		}
+	}
+	for _, name := range conf.Curves {
+		if _, ok := tlsCurves[name]; !ok {
+			return "The specified curve is not supported. Possible values are: X25519 | P256 | P384 | P521"
+		}
+	}
+	for _, protocol := range conf.ALPN {
+		if protocol == "h2" {
+			return "HTTP/2 is not supported, \"h2\" cannot be advertised through ALPN"
+		}
+	}
+	return ""
+}
+
+// newTLSConfig Builds the configuration of the TLS listener. Parameters are expected to be already validated
+func newTLSConfig(conf *TLS) *tls.Config {
+	tlsConfig := &tls.Config{
+		GetCertificate:         getCertificate,
+		ClientAuth:             clientAuthTypes[conf.ClientAuth],
+		ClientCAs:              clientCAs,
+		MinVersion:             tlsVersions[conf.MinVersion],
+		MaxVersion:             tlsVersions[conf.MaxVersion],
+		NextProtos:             conf.ALPN,
+		SessionTicketsDisabled: conf.SessionTicketsDisabled,
+	}
+	for _, name := range conf.CipherSuites {
+		suite, _ := cipherSuite(name)
+		tlsConfig.CipherSuites = append(tlsConfig.CipherSuites, suite.ID)
+	}
+	// TLS-ALPN-01 challenges are told apart from regular handshakes through ALPN
+	if conf.ACME.Enabled {
+		if len(tlsConfig.NextProtos) == 0 {
+			tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+		tlsConfig.NextProtos = append(tlsConfig.NextProtos, acme.ALPNProto)
+		// ACME servers validating a challenge never present a client certificate
+		if tlsConfig.ClientAuth != tls.NoClientCert {
+			challengeConfig := tlsConfig.Clone()
+			challengeConfig.ClientAuth = tls.NoClientCert
+			tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+				if len(hello.SupportedProtos) == 1 && hello.SupportedProtos[0] == acme.ALPNProto {
+					return challengeConfig, nil
+				}
+				return nil, nil
+			}
+		}
+	}
+	for _, name := range conf.Curves {
+		tlsConfig.CurvePreferences = append(tlsConfig.CurvePreferences, tlsCurves[name])
+	}
+	return tlsConfig
+}
+
+// readSessionTicketKeys Reads the session ticket keys from a file holding one or more 32 bytes keys back to back. The
+// first one encrypts new tickets, the following ones are only used to decrypt tickets issued before a rotation
+func readSessionTicketKeys(path string) ([][SESSION_TICKET_KEY_SIZE]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 || len(content)%SESSION_TICKET_KEY_SIZE != 0 {
+		return nil, errSessionTicketKeys
+	}
+	keys := make([][SESSION_TICKET_KEY_SIZE]byte, len(content)/SESSION_TICKET_KEY_SIZE)
+	for i := range keys {
+		copy(keys[i][:], content[i*SESSION_TICKET_KEY_SIZE:])
+	}
+	return keys, nil
+}
+
+// reloadSessionTicketKeys Replaces the session ticket keys with the ones in the key file. On failure the previous keys
+// are kept in place
+func reloadSessionTicketKeys(tlsConfig *tls.Config, path string) {
+	keys, err := readSessionTicketKeys(path)
+	if err != nil {
+		logChannel.channel <- Log{level: ERROR, message: "Error while reloading session ticket keys, keeping the previous ones", data: []zap.Field{zap.Error(err)}}
+		return
+	}
+	tlsConfig.SetSessionTicketKeys(keys)
+	logChannel.channel <- Log{level: INFO, message: "Reloaded session ticket keys", data: []zap.Field{zap.Int("keys", len(keys))}}
+}
+
+// watchSessionTicketKeys Reloads the session ticket keys whenever their file, possibly shared across instances and
+// rotated by an external process, is modified
+func watchSessionTicketKeys(tlsConfig *tls.Config, path string) {
+	var modTime time.Time
+	if stat, err := os.Stat(path); err == nil {
+		modTime = stat.ModTime()
+	}
+	for range time.Tick(SessionTicketKeyPollInterval) {
+		stat, err := os.Stat(path)
+		if err != nil || stat.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = stat.ModTime()
+		reloadSessionTicketKeys(tlsConfig, path)
+	}
+}
+
+// reloadTLSOnSignal Reloads every certificate, along with the session ticket keys, when the process receives SIGHUP
+func reloadTLSOnSignal(tlsConfig *tls.Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		reloadCertificates(nil)
+		if config.Minosse.TLS.SessionTicketKeyFile != "" {
+			reloadSessionTicketKeys(tlsConfig, config.Minosse.TLS.SessionTicketKeyFile)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateTLSParameters(t *testing.T) {
+	tests := []struct {
+		name  string
+		conf  TLS
+		valid bool
+	}{
+		{"defaults", TLS{MinVersion: "1.2"}, true},
+		{"version range", TLS{MinVersion: "1.2", MaxVersion: "1.3"}, true},
+		{"single version", TLS{MinVersion: "1.3", MaxVersion: "1.3"}, true},
+		{"unknown minimum version", TLS{MinVersion: "1.4"}, false},
+		{"missing minimum version", TLS{}, false},
+		{"unknown maximum version", TLS{MinVersion: "1.2", MaxVersion: "TLS1.3"}, false},
+		{"maximum below minimum", TLS{MinVersion: "1.3", MaxVersion: "1.2"}, false},
+		{"cipher suites", TLS{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}}, true},
+		{"insecure cipher suite", TLS{MinVersion: "1.2", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, true},
+		{"unknown cipher suite", TLS{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM"}}, false},
+		{"TLS 1.3 cipher suite", TLS{MinVersion: "1.2", CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}}, false},
+		{"curves", TLS{MinVersion: "1.2", Curves: []string{"X25519", "P256", "P384", "P521"}}, true},
+		{"unknown curve", TLS{MinVersion: "1.2", Curves: []string{"P224"}}, false},
+		{"ALPN", TLS{MinVersion: "1.2", ALPN: []string{"http/1.1"}}, true},
+		{"HTTP/2 through ALPN", TLS{MinVersion: "1.2", ALPN: []string{"h2", "http/1.1"}}, false},
+	}
+	for _, test := range tests {
+		if message := validateTLSParameters(&test.conf); (message == "") != test.valid {
+			t.Errorf("%s: got %q, want valid %t", test.name, message, test.valid)
+		}
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	tlsConfig := newTLSConfig(&TLS{
+		MinVersion:   "1.2",
+		MaxVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+		Curves:       []string{"P384", "X25519"},
+		ALPN:         []string{"http/1.1"},
+		ClientAuth:   CLIENT_AUTH_REQUIRE,
+	})
+	if tlsConfig.MinVersion != tls.VersionTLS12 || tlsConfig.MaxVersion != tls.VersionTLS13 {
+		t.Errorf("got versions %x-%x, want 1.2-1.3", tlsConfig.MinVersion, tlsConfig.MaxVersion)
+	}
+	if len(tlsConfig.CipherSuites) != 1 || tlsConfig.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("got cipher suites %v", tlsConfig.CipherSuites)
+	}
+	if len(tlsConfig.CurvePreferences) != 2 || tlsConfig.CurvePreferences[0] != tls.CurveP384 || tlsConfig.CurvePreferences[1] != tls.X25519 {
+		t.Errorf("got curves %v, want P384 then X25519", tlsConfig.CurvePreferences)
+	}
+	if len(tlsConfig.NextProtos) != 1 || tlsConfig.ClientAuth != tls.RequireAnyClientCert {
+		t.Errorf("got ALPN %v and client auth %v", tlsConfig.NextProtos, tlsConfig.ClientAuth)
+	}
+	if tlsConfig := newTLSConfig(&TLS{MinVersion: "1.2"}); tlsConfig.MaxVersion != 0 || tlsConfig.CipherSuites != nil {
+		t.Errorf("got maximum version %x and cipher suites %v, want the defaults of crypto/tls", tlsConfig.MaxVersion, tlsConfig.CipherSuites)
+	}
+}
+
+func TestReadSessionTicketKeys(t *testing.T) {
+	first := bytes.Repeat([]byte{1}, SESSION_TICKET_KEY_SIZE)
+	second := bytes.Repeat([]byte{2}, SESSION_TICKET_KEY_SIZE)
+
+	tests := []struct {
+		name    string
+		content []byte
+		keys    int
+	}{
+		{"single key", first, 1},
+		{"rotated keys", append(append([]byte{}, first...), second...), 2},
+		{"empty file", nil, 0},
+		{"truncated key", first[:16], 0},
+		{"trailing bytes", append(append([]byte{}, first...), 0), 0},
+	}
+	for _, test := range tests {
+		path := filepath.Join(t.TempDir(), "ticket.keys")
+		if err := ioutil.WriteFile(path, test.content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := readSessionTicketKeys(path)
+		if test.keys == 0 {
+			if err != errSessionTicketKeys {
+				t.Errorf("%s: got %d keys (%v), want an error", test.name, len(keys), err)
+			}
+			continue
+		}
+		if err != nil || len(keys) != test.keys {
+			t.Errorf("%s: got %d keys (%v), want %d", test.name, len(keys), err, test.keys)
+			continue
+		}
+		// The first key of the file encrypts new tickets
+		if !bytes.Equal(keys[0][:], first) || (test.keys == 2 && !bytes.Equal(keys[1][:], second)) {
+			t.Errorf("%s: keys not read in order", test.name)
+		}
+	}
+
+	if _, err := readSessionTicketKeys(filepath.Join(t.TempDir(), "missing.keys")); err == nil {
+		t.Errorf("no error for a missing file")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// TRY_FILES_URI placeholder replaced by the request path in try_files candidates
+const TRY_FILES_URI string = "$uri"
+
+// resolveTryFiles Resolves the file to serve for u. Without try_files candidates this is just the file addressed by
+// the request path; otherwise the first candidate that exists wins. Candidates not depending on the request path
+// (e.g. "/index.html") are fallbacks, never used for paths whose extension is listed in NoFallbackExtensions.
+// Returns the URL path of the chosen candidate along with its file path
+func (vh *virtualHost) resolveTryFiles(u *url.URL) (string, string, error) {
+	if err := vh.resolver.validate(u); err != nil {
+		return "", "", err
+	}
+	if len(vh.TryFiles.Candidates) == 0 {
+		pathFile, err := vh.resolver.resolvePath(u.Path)
+		return u.Path, pathFile, err
+	}
+
+	noFallback := vh.noFallbackExtension(u.Path)
+	var lastErr error = os.ErrNotExist
+	for _, candidate := range vh.TryFiles.Candidates {
+		isFallback := !strings.Contains(candidate, TRY_FILES_URI)
+		if isFallback && noFallback {
+			continue
+		}
+
+		candidatePath := strings.Replace(candidate, TRY_FILES_URI, u.Path, -1)
+		pathFile, err := vh.resolver.resolvePath(candidatePath)
+		if err == nil {
+			return candidatePath, pathFile, nil
+		}
+		// Candidates are alternatives to a missing file, not to a forbidden one
+		if err == errOutsideWebRoot || err == errSymlinkNotAllowed || err == errInvalidPath {
+			return "", "", err
+		}
+		lastErr = err
+	}
+	return "", "", lastErr
+}
+
+func (vh *virtualHost) noFallbackExtension(urlPath string) bool {
+	ext := strings.ToLower(path.Ext(urlPath))
+	if ext == "" {
+		return false
+	}
+	for _, noFallback := range vh.TryFiles.NoFallbackExtensions {
+		if noFallback == "*" || noFallback == ext {
+			return true
+		}
+	}
+	return false
+}
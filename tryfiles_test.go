@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTryFiles(t *testing.T) {
+	files := map[string]string{
+		"index.html":      "app",
+		"about.html":      "about",
+		"docs/index.html": "docs",
+		"app.js":          "script",
+	}
+
+	tests := []struct {
+		name       string
+		candidates []string
+		noFallback []string
+		target     string
+		status     int
+		body       string
+	}{
+		{"existing file", []string{"$uri", "/index.html"}, nil, "/app.js", 200, "script"},
+		{"client-side route", []string{"$uri", "/index.html"}, nil, "/users/42", 200, "app"},
+		{"client-side route with query", []string{"$uri", "/index.html"}, nil, "/users/42?tab=1", 200, "app"},
+		{"extension candidate", []string{"$uri", "$uri.html", "/index.html"}, nil, "/about", 200, "about"},
+		{"directory candidate", []string{"$uri", "/index.html"}, nil, "/docs/", 200, "docs"},
+		{"missing asset", []string{"$uri", "/index.html"}, nil, "/missing.js", 404, ""},
+		{"missing asset with uppercase extension", []string{"$uri", "/index.html"}, nil, "/missing.CSS", 404, ""},
+		{"asset candidate still tried", []string{"$uri", "$uri.html", "/index.html"}, nil, "/about.js", 404, ""},
+		{"custom extensions", []string{"$uri", "/index.html"}, []string{"txt"}, "/missing.js", 200, "app"},
+		{"custom extensions matching", []string{"$uri", "/index.html"}, []string{"txt"}, "/missing.txt", 404, ""},
+		{"no fallback at all", []string{"$uri", "/index.html"}, []string{"*"}, "/missing.html", 404, ""},
+		{"no fallback without extension", []string{"$uri", "/index.html"}, []string{"*"}, "/route", 200, "app"},
+		{"missing fallback", []string{"$uri", "/missing.html"}, nil, "/route", 404, ""},
+		{"invalid path", []string{"$uri", "/index.html"}, nil, "/a%2fb", 400, ""},
+		{"hidden file", []string{"$uri", "/index.html"}, nil, "/.env", 404, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.Minosse.TryFiles = TryFiles{Candidates: test.candidates, NoFallbackExtensions: test.noFallback}
+			writeTestFiles(t, conf.Minosse.WebRoot, files)
+			addr := startTestServer(t)
+
+			res := fetch(t, addr, rawRequest("GET", test.target))
+			if res.StatusCode != test.status {
+				t.Fatalf("got status %d, want %d", res.StatusCode, test.status)
+			}
+			if test.body != "" && res.body != test.body {
+				t.Errorf("got %q, want %q", res.body, test.body)
+			}
+		})
+	}
+}
+
+func TestTryFilesDirectoryRedirect(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.TryFiles = TryFiles{Candidates: []string{"$uri", "/index.html"}}
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"index.html": "app", "docs/index.html": "docs"})
+	addr := startTestServer(t)
+
+	res := fetch(t, addr, rawRequest("GET", "/docs?q=1"))
+	if res.StatusCode != 301 || res.Header.Get("Location") != "/docs/?q=1" {
+		t.Errorf("got %d with Location %q, want 301 to /docs/?q=1", res.StatusCode, res.Header.Get("Location"))
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"path"
 	"strings"
 )
 
@@ -14,6 +15,22 @@ func HashmapMapToString(m map[string]string, f func(string, string) string) stri
 	return str.String()
 }
 
+// pathHasPrefix Reports whether the URL path, once cleaned, is prefix or lies below it. Whole segments are compared, so
+// that "/internal" does not match "/internalfoo"
+func pathHasPrefix(urlPath, prefix string) bool {
+	urlPath = path.Clean("/" + urlPath)
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// byteCounter An io.Writer that discards its input, only counting the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
 func PrintMinosse() {
 	asciiArt :=
 		`
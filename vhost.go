@@ -0,0 +1,221 @@
+package main
+
+import (
+	"compress/gzip"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pelletier/go-toml"
+	"go.uber.org/zap"
+)
+
+// virtualHost A site along with the state derived from its configuration
+type virtualHost struct {
+	Site
+	resolver       *pathResolver
+	accessControl  *accessRules
+	excludePattern *regexp.Regexp
+	// certificate loaded from Certificate, only when TLS is enabled
+	certificate *reloadableCertificate
+}
+
+var virtualHosts []*virtualHost
+var defaultHost *virtualHost
+var hostsByName = make(map[string]*virtualHost)
+
+// selectVirtualHost Chooses the site serving the given host, as found in the Host header or in the TLS server name.
+// Exact names win over wildcards, and unknown hosts get the default site
+func selectVirtualHost(host string) *virtualHost {
+	host = normalizeHost(host)
+	if vh, ok := hostsByName[host]; ok {
+		return vh
+	}
+	for i := strings.IndexByte(host, '.'); i >= 0; i = strings.IndexByte(host, '.') {
+		host = host[i+1:]
+		if vh, ok := hostsByName["*."+host]; ok {
+			return vh
+		}
+	}
+	return defaultHost
+}
+
+// normalizeHost Lowercases a host name, dropping its port and trailing dot
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// inheritSite Builds the configuration of a [[site]] out of its table, taking every setting it does not override from
+// the default one
+func inheritSite(defaults Site, table *toml.Tree) (Site, error) {
+	site := defaults
+	site.Hosts, site.Default, site.Certificate = nil, false, Certificate{}
+	if err := table.Unmarshal(&site); err != nil {
+		return site, err
+	}
+	// Error pages are merged, so that a site can override a single status code
+	if table.Has("errorPages") {
+		for code, page := range defaults.ErrorPages {
+			if _, ok := site.ErrorPages[code]; !ok {
+				site.ErrorPages[code] = page
+			}
+		}
+	}
+	return site, nil
+}
+
+// registerVirtualHost Makes a site reachable through its host names
+func registerVirtualHost(vh *virtualHost) {
+	virtualHosts = append(virtualHosts, vh)
+	for _, host := range vh.Hosts {
+		host = normalizeHost(host)
+		if _, ok := hostsByName[host]; ok {
+			logChannel.fatalError("The same host is used by more than one site", nil)
+		}
+		hostsByName[host] = vh
+	}
+}
+
+// newVirtualHost Applies default values to the settings of a site, validating them, and prepares everything needed to
+// serve it
+func newVirtualHost(site *Site) *virtualHost {
+	vh := &virtualHost{}
+	// Web root
+	if site.WebRoot == "" {
+		logChannel.fatalError("No webroot was specified in current configuration", nil)
+	} else {
+		logChannel.channel <- Log{level: INFO, message: "Serving static files", data: []zap.Field{zap.String("Directory", site.WebRoot), zap.Strings("hosts", site.Hosts)}}
+		switch site.Symlinks {
+		case "":
+			logChannel.channel <- Log{level: INFO, message: "Using default symlink policy: symlinks are always followed. Use deny or ownerMatch to keep them from leading outside of the webroot"}
+			site.Symlinks = SYMLINKS_FOLLOW
+		case SYMLINKS_FOLLOW, SYMLINKS_DENY, SYMLINKS_OWNER_MATCH:
+		default:
+			logChannel.fatalError("The specified symlink policy is not valid. Possible values are: follow | deny | ownerMatch", nil)
+		}
+		var err error
+		if vh.resolver, err = newPathResolver(site.WebRoot, site.Symlinks); err != nil {
+			logChannel.fatalError("The specified webroot cannot be accessed", err)
+		}
+	}
+	// Cache-Control
+	if site.CacheControl == "" {
+		site.CacheControl = HEADER_CACHE_CONTROL_DEFAULT_VALUE
+	}
+	// Directory index
+	if site.Index == nil {
+		site.Index = []string{"index.html", "index.htm"}
+	}
+	if site.NoIndexStatus == 0 {
+		site.NoIndexStatus = 403
+	} else if site.NoIndexStatus != 403 && site.NoIndexStatus != 404 {
+		logChannel.fatalError("The specified status for directories without index is not valid. Possible values are: 403 | 404", nil)
+	}
+	// Try files
+	if len(site.TryFiles.Candidates) > 0 {
+		if site.TryFiles.NoFallbackExtensions == nil {
+			site.TryFiles.NoFallbackExtensions = []string{".js", ".mjs", ".css", ".map", ".json", ".ico", ".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".woff", ".woff2"}
+		}
+		for i, ext := range site.TryFiles.NoFallbackExtensions {
+			if ext != "*" && !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			site.TryFiles.NoFallbackExtensions[i] = strings.ToLower(ext)
+		}
+		logChannel.channel <- Log{level: INFO, message: "Using try_files candidates", data: []zap.Field{zap.Strings("candidates", site.TryFiles.Candidates), zap.Strings("noFallbackExtensions", site.TryFiles.NoFallbackExtensions)}}
+	}
+	// Autoindex
+	if site.Autoindex.Enabled {
+		if len(site.Autoindex.Prefixes) == 0 {
+			site.Autoindex.Prefixes = []string{"/"}
+		}
+		for i, prefix := range site.Autoindex.Prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				prefix = "/" + prefix
+			}
+			if !strings.HasSuffix(prefix, "/") {
+				prefix += "/"
+			}
+			site.Autoindex.Prefixes[i] = prefix
+		}
+		logChannel.channel <- Log{level: INFO, message: "Directory listing enabled", data: []zap.Field{zap.Strings("prefixes", site.Autoindex.Prefixes)}}
+	}
+	// Error pages
+	if !validateErrorPages(site.ErrorPages) {
+		logChannel.fatalError("The specified error pages are not valid. Keys must be error status codes (400-599) and values absolute paths inside the webroot", nil)
+	}
+	// Access rules
+	if site.Access.DotfilesAllowlist == nil {
+		site.Access.DotfilesAllowlist = []string{".well-known"}
+	}
+	if !site.Access.AllowDotfiles {
+		logChannel.channel <- Log{level: INFO, message: "Hiding dotfiles", data: []zap.Field{zap.Strings("allowlist", site.Access.DotfilesAllowlist)}}
+	}
+	for i, prefix := range site.Access.ClientCertPrefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			site.Access.ClientCertPrefixes[i] = "/" + prefix
+		}
+	}
+	rules, err := newAccessRules(site.Access)
+	if err != nil {
+		logChannel.fatalError("The specified deny rules are not valid glob patterns or regular expressions", err)
+	}
+	vh.accessControl = rules
+	// Compression
+	if site.Compression.Enabled {
+		if len(site.Compression.Encodings) == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default content encodings preference order: br, zstd, gzip"}
+			site.Compression.Encodings = []string{BROTLI, ZSTD, GZIP}
+		}
+		for i, encoding := range site.Compression.Encodings {
+			encoding = strings.ToLower(encoding)
+			if encoding != BROTLI && encoding != ZSTD && encoding != GZIP && encoding != IDENTITY {
+				logChannel.fatalError("The specified content encoding is not supported. Possible values are: br | zstd | gzip | identity", nil)
+			}
+			site.Compression.Encodings[i] = encoding
+		}
+		if site.Compression.Exclude == "" {
+			logChannel.channel <- Log{level: INFO, message: "Using default compression configuration will NOT compress images and pdf files"}
+			site.Compression.Exclude = "(jpeg|jpg|png|pdf)$"
+		}
+		if site.Compression.GzipLevel == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default gzip compression level. You can specify the compression level in the configuration file; possible values range from 1 (Best speed) to 9 (Best compression)"}
+			site.Compression.GzipLevel = gzip.DefaultCompression
+		} else if site.Compression.GzipLevel > 9 {
+			logChannel.fatalError("The specified gzip level is not valid. Possible values range from 1 (Best speed) to 9 (Best compression)", nil)
+		}
+		if site.Compression.BrotliLevel == 0 {
+			site.Compression.BrotliLevel = brotli.DefaultCompression
+		} else if site.Compression.BrotliLevel < 0 || site.Compression.BrotliLevel > 11 {
+			logChannel.fatalError("The specified brotli level is not valid. Possible values range from 1 (Best speed) to 11 (Best compression)", nil)
+		}
+		if site.Compression.ZstdLevel == 0 {
+			site.Compression.ZstdLevel = 3
+		} else if site.Compression.ZstdLevel < 0 || site.Compression.ZstdLevel > 22 {
+			logChannel.fatalError("The specified zstd level is not valid. Possible values range from 1 (Best speed) to 22 (Best compression)", nil)
+		}
+		if site.Compression.Threshold == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default compression file-size threshold. File under 1.5KB will not be compressed."}
+			site.Compression.Threshold = 1500
+		} else if site.Compression.Threshold < 0 {
+			logChannel.fatalError("The specified compression file size threshold is invalid because it is negative.", nil)
+		}
+		if site.Compression.MaxBufferedSize == 0 {
+			site.Compression.MaxBufferedSize = CompressionMaxBufferedSize
+			if site.Compression.Stream {
+				logChannel.channel <- Log{level: INFO, message: "Using default compression buffer cap. Files over 1MB will be compressed while streaming them."}
+			} else {
+				logChannel.channel <- Log{level: INFO, message: "Using default compression buffer cap. Files over 1MB will not be compressed, unless a precompressed file is available."}
+			}
+		} else if site.Compression.MaxBufferedSize < 0 {
+			logChannel.fatalError("The specified compression buffer cap is invalid because it is negative.", nil)
+		}
+		vh.excludePattern = regexp.MustCompile(site.Compression.Exclude)
+	}
+	vh.Site = *site
+	return vh
+}
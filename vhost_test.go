@@ -0,0 +1,212 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pelletier/go-toml"
+)
+
+// setTestSites Declares the [[site]] tables found in document, as if they were read from the configuration file
+func setTestSites(t *testing.T, conf *Config, document string) {
+	t.Helper()
+	tree, err := toml.Load(document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.siteTables, _ = tree.Get("site").([]*toml.Tree)
+}
+
+// hostRequest Builds a GET request for target with the given Host header
+func hostRequest(target, host string) string {
+	return "GET " + target + " HTTP/1.1\r\nHost: " + host + "\r\n\r\n"
+}
+
+func TestSelectVirtualHost(t *testing.T) {
+	exact, wildcard, deeper, fallback := &virtualHost{}, &virtualHost{}, &virtualHost{}, &virtualHost{}
+	previousHosts, previousDefault := hostsByName, defaultHost
+	t.Cleanup(func() { hostsByName, defaultHost = previousHosts, previousDefault })
+	hostsByName = map[string]*virtualHost{"example.com": exact, "*.example.com": wildcard, "*.eu.example.com": deeper, "www.example.com": exact}
+	defaultHost = fallback
+
+	tests := []struct {
+		host string
+		vh   *virtualHost
+	}{
+		{"example.com", exact},
+		{"EXAMPLE.com", exact},
+		{"example.com:8080", exact},
+		{"example.com.", exact},
+		{"example.com.:443", exact},
+		// Exact names win over wildcards
+		{"www.example.com", exact},
+		{"blog.example.com", wildcard},
+		{"a.b.example.com", wildcard},
+		// The most specific wildcard wins
+		{"shop.eu.example.com", deeper},
+		{"eu.example.com", wildcard},
+		{"notexample.com", fallback},
+		{"com", fallback},
+		{"", fallback},
+		{"127.0.0.1:8080", fallback},
+		{"[::1]:8080", fallback},
+	}
+	for _, test := range tests {
+		if vh := selectVirtualHost(test.host); vh != test.vh {
+			t.Errorf("selectVirtualHost(%q) chose the wrong site", test.host)
+		}
+	}
+}
+
+func TestInheritSite(t *testing.T) {
+	defaults := Site{
+		WebRoot:      "public",
+		Symlinks:     SYMLINKS_DENY,
+		Index:        []string{"index.html"},
+		CacheControl: "no-cache",
+		Compression:  Compression{Enabled: true, GzipLevel: 5, Encodings: []string{GZIP}},
+		ErrorPages:   map[string]string{"404": "/404.html", "500": "/500.html"},
+		Hosts:        []string{"default.example"},
+		Default:      true,
+		Certificate:  Certificate{X509CertPath: "default.crt", X509KeyPath: "default.key"},
+	}
+	tree, err := toml.Load(`
+hosts = ["example.com"]
+webroot = "sites/example"
+cacheControl = "public, max-age=3600"
+[compression]
+gzipLevel = 9
+[errorPages]
+404 = "/example-404.html"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	site, err := inheritSite(defaults, tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Overridden settings
+	if !reflect.DeepEqual(site.Hosts, []string{"example.com"}) || site.WebRoot != "sites/example" || site.CacheControl != "public, max-age=3600" {
+		t.Errorf("overridden settings not applied: %+v", site)
+	}
+	if site.Compression.GzipLevel != 9 {
+		t.Errorf("got gzip level %d, want 9", site.Compression.GzipLevel)
+	}
+	// Inherited settings
+	if site.Symlinks != SYMLINKS_DENY || !reflect.DeepEqual(site.Index, []string{"index.html"}) {
+		t.Errorf("settings not inherited: %+v", site)
+	}
+	if !site.Compression.Enabled || !reflect.DeepEqual(site.Compression.Encodings, []string{GZIP}) {
+		t.Errorf("compression settings not inherited field by field: %+v", site.Compression)
+	}
+	// Error pages are merged per status code
+	if want := map[string]string{"404": "/example-404.html", "500": "/500.html"}; !reflect.DeepEqual(site.ErrorPages, want) {
+		t.Errorf("got error pages %v, want %v", site.ErrorPages, want)
+	}
+	if defaults.ErrorPages["404"] != "/404.html" {
+		t.Errorf("the error pages of the default site were modified: %v", defaults.ErrorPages)
+	}
+	// Settings which identify a site are never inherited
+	if site.Default || site.Certificate != (Certificate{}) {
+		t.Errorf("got default %t and certificate %+v, want neither", site.Default, site.Certificate)
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.CacheControl = "no-cache"
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"index.html": "main"})
+	example, other := t.TempDir(), t.TempDir()
+	writeTestFiles(t, example, map[string]string{"index.html": "example", "only-example.txt": "example"})
+	writeTestFiles(t, other, map[string]string{"index.html": "other"})
+	setTestSites(t, conf, `
+[[site]]
+hosts = ["example.com", "*.example.com"]
+webroot = "`+example+`"
+cacheControl = "public, max-age=3600"
+[[site]]
+hosts = ["other.org"]
+webroot = "`+other+`"
+`)
+	addr := startTestServer(t)
+
+	tests := []struct {
+		target       string
+		host         string
+		status       int
+		body         string
+		cacheControl string
+	}{
+		{"/", "example.com", 200, "example", "public, max-age=3600"},
+		{"/", "www.example.com:8080", 200, "example", "public, max-age=3600"},
+		{"/only-example.txt", "example.com", 200, "example", "public, max-age=3600"},
+		{"/", "other.org", 200, "other", "no-cache"},
+		{"/only-example.txt", "other.org", 404, "", ""},
+		{"/", "unknown.net", 200, "main", "no-cache"},
+		{"/", "localhost", 200, "main", "no-cache"},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, hostRequest(test.target, test.host))
+		if res.StatusCode != test.status || (test.body != "" && res.body != test.body) {
+			t.Errorf("%s%s: got %d %q, want %d %q", test.host, test.target, res.StatusCode, res.body, test.status, test.body)
+		}
+		if test.cacheControl != "" && res.Header.Get("Cache-Control") != test.cacheControl {
+			t.Errorf("%s%s: got Cache-Control %q, want %q", test.host, test.target, res.Header.Get("Cache-Control"), test.cacheControl)
+		}
+	}
+}
+
+func TestDefaultVirtualHost(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"index.html": "main"})
+	fallback := t.TempDir()
+	writeTestFiles(t, fallback, map[string]string{"index.html": "fallback"})
+	setTestSites(t, conf, `
+[[site]]
+default = true
+webroot = "`+fallback+`"
+`)
+	addr := startTestServer(t)
+
+	if res := fetch(t, addr, hostRequest("/", "unknown.net")); res.body != "fallback" {
+		t.Errorf("got %q for an unknown host, want the default site", res.body)
+	}
+}
+
+func TestDefaultSiteWithoutGlobalWebRoot(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.WebRoot = ""
+	conf.Minosse.CacheControl = "no-cache"
+	fallback, example := t.TempDir(), t.TempDir()
+	writeTestFiles(t, fallback, map[string]string{"index.html": "fallback"})
+	writeTestFiles(t, example, map[string]string{"index.html": "example"})
+	setTestSites(t, conf, `
+[[site]]
+hosts = ["example.com"]
+webroot = "`+example+`"
+[[site]]
+default = true
+webroot = "`+fallback+`"
+`)
+	addr := startTestServer(t)
+
+	if len(virtualHosts) != 2 {
+		t.Errorf("got %d sites, want the two [[site]] tables only", len(virtualHosts))
+	}
+	tests := []struct {
+		host string
+		body string
+	}{
+		{"example.com", "example"},
+		{"unknown.net", "fallback"},
+		{"", "fallback"},
+	}
+	for _, test := range tests {
+		res := fetch(t, addr, hostRequest("/", test.host))
+		if res.body != test.body || res.Header.Get("Cache-Control") != "no-cache" {
+			t.Errorf("%q: got %q with Cache-Control %q, want %q with the inherited no-cache", test.host, res.body, res.Header.Get("Cache-Control"), test.body)
+		}
+	}
+}
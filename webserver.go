@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -13,13 +12,13 @@ import (
 	"mime"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
-	"path/filepath"
-	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/libp2p/go-reuseport"
@@ -27,16 +26,41 @@ import (
 	"go.uber.org/ratelimit"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 const SocketReadTimeout = 30
 const SocketWriteTimeout = 30
+const SocketIdleTimeout = 5
+const MaxRequestsPerConnection = 100
+const MaxDiscardedBodySize = 256 << 10
+const CompressionMaxBufferedSize = 1 << 20
+const CompressionCacheMaxBytes = 64 << 20
+const CompressionCacheMaxEntries = 1024
+const CacheStatsInterval = time.Minute
+const FileCacheMaxFileSize = 1 << 20
+const FileCacheMaxBytes = 64 << 20
+const FileCacheMaxEntries = 10000
+const FileCachePollInterval = 2
+const DigestCacheMaxBytes = 4 << 20
+const DigestCacheMaxEntries = 10000
+const SendfileChunkSize = 2 << 20
+const SendfileMinSize = 16 << 10
+const AutoindexReadBatch = 1024
+const AutoindexBufferSize = 32 << 10
+const CertificateReloadDelay = time.Second
+const SessionTicketKeyPollInterval = time.Minute
+const ACMECacheDir = "acme"
+const SelfSignedCertificateValidity = 30 * 24 * time.Hour
 
 var config Config
 var logChannel LogChannel
-var excludePattern *regexp.Regexp
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == GEN_CERT_COMMAND {
+		os.Exit(genCert(os.Args[2:]))
+	}
+
 	PrintMinosse()
 	configure(&config)
 	configureLogger()
@@ -52,31 +76,44 @@ func main() {
 	logChannel.channel <- Log{
 		level:   INFO,
 		message: "Minosse server started",
-		data:    []zap.Field{zap.String("address", config.Minosse.Server), zap.Int("port", config.Minosse.Port), zap.String("root", config.Minosse.WebRoot)},
+		data:    []zap.Field{zap.String("address", config.Minosse.Server), zap.Int("port", config.Minosse.Port), zap.String("root", defaultHost.WebRoot)},
 	}
 
 	if config.Minosse.TLS.Enabled {
-		cert, err := tls.LoadX509KeyPair(config.Minosse.TLS.X509CertPath, config.Minosse.TLS.X509KeyPath)
-		if err != nil {
-			logChannel.fatalError("Fatal error while loading x509 keypair", err)
-			return
+		loadCertificates(&config.Minosse.TLS)
+		if config.Minosse.TLS.AutoSelfSigned {
+			addSelfSignedCertificate()
+		}
+		if config.Minosse.TLS.ACME.Enabled {
+			acmeManager = newACMEManager(&config.Minosse.TLS.ACME)
 		}
-		var rootCAPool *x509.CertPool
+		go watchCertificates()
+
 		if config.Minosse.TLS.X509RootCAPath != "" {
-			rootCAPool = x509.NewCertPool()
+			clientCAs = x509.NewCertPool()
 			rootCA, err := ioutil.ReadFile(config.Minosse.TLS.X509RootCAPath)
 			if err != nil {
-				logChannel.error("Could not read root CA file", err)
+				logChannel.fatalError("Could not read root CA file", err)
 				return
 			}
-			ok := rootCAPool.AppendCertsFromPEM(rootCA)
+			ok := clientCAs.AppendCertsFromPEM(rootCA)
 			if !ok {
-				logChannel.error("Unable to use supplied root CA cert. Make sure it is a valid certificate", nil)
+				logChannel.fatalError("Unable to use supplied root CA cert. Make sure it is a valid certificate", nil)
 				return
 			}
 		}
 
-		tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: rootCAPool, MinVersion: tls.VersionTLS12}
+		tlsConfig := newTLSConfig(&config.Minosse.TLS)
+		if config.Minosse.TLS.SessionTicketKeyFile != "" {
+			keys, err := readSessionTicketKeys(config.Minosse.TLS.SessionTicketKeyFile)
+			if err != nil {
+				logChannel.fatalError("Could not read session ticket key file", err)
+				return
+			}
+			tlsConfig.SetSessionTicketKeys(keys)
+			go watchSessionTicketKeys(tlsConfig, config.Minosse.TLS.SessionTicketKeyFile)
+		}
+		go reloadTLSOnSignal(tlsConfig)
 
 		tlsListener, err := tls.Listen("tcp", fmt.Sprintf("%s:%d", config.Minosse.Server, config.Minosse.TLS.Port), tlsConfig)
 		if err != nil {
@@ -92,6 +129,29 @@ func main() {
 		go listen(tlsListener, newConnections)
 	}
 
+	if config.Minosse.Compression.Cache.Enabled {
+		compressedCache = newLRUCache(config.Minosse.Compression.Cache.MaxBytes, config.Minosse.Compression.Cache.MaxEntries)
+		go compressedCache.logStats("compressed", CacheStatsInterval)
+	}
+	for _, vh := range virtualHosts {
+		if vh.ETag.Enabled && vh.ETag.Hash && digestCache == nil {
+			digestCache = newLRUCache(DigestCacheMaxBytes, DigestCacheMaxEntries)
+			go digestCache.logStats("etag digests", CacheStatsInterval)
+		}
+	}
+
+	if config.Minosse.Cache.Enabled {
+		fileCache = newLRUCache(config.Minosse.Cache.MaxBytes, config.Minosse.Cache.MaxEntries)
+		go fileCache.logStats("file", CacheStatsInterval)
+		watched := make(map[string]bool)
+		for _, vh := range virtualHosts {
+			if !watched[vh.resolver.root] {
+				watched[vh.resolver.root] = true
+				go watchWebRoot(vh.resolver.root, nil)
+			}
+		}
+	}
+
 	var rl ratelimit.Limiter
 	if config.Minosse.Connections.MaxConnections > 0 {
 		rl = ratelimit.New(config.Minosse.Connections.MaxConnections)
@@ -99,25 +159,27 @@ func main() {
 		rl = ratelimit.NewUnlimited()
 	}
 
-	maxWorkers := config.Minosse.MaxProcessNumber
-	for w := 0; w < maxWorkers; w++ {
-		go worker(newConnections, rl)
-	}
+	go serve(newConnections, newWorkers(config.Minosse.MaxProcessNumber), rl)
 
 	listen(listener, newConnections)
 }
 
 func configure(conf *Config) {
 	// TODO: read from cli --flags
-	confFile, err := ioutil.ReadFile("./config/config.example.toml")
+	confFile, err := ioutil.ReadFile(CONFIG_FILE_PATH)
 	if err != nil {
 		logChannel.error("WARNING: Could not read minosse configuration file", err)
 	}
 
-	err = toml.Unmarshal(confFile, conf)
+	tree, err := toml.LoadBytes(confFile)
+	if err == nil {
+		err = tree.Unmarshal(conf)
+	}
 	if err != nil {
 		logChannel.error("WARNING: Error in minosse configuration file", err)
+		return
 	}
+	conf.siteTables, _ = tree.Get("site").([]*toml.Tree)
 }
 
 func applyDefaultConfigValues(conf *Config) {
@@ -140,24 +202,57 @@ func applyDefaultConfigValues(conf *Config) {
 	}
 	// X509 - TLS
 	if conf.Minosse.TLS.Enabled {
-		if conf.Minosse.TLS.X509CertPath == "" {
-			logChannel.fatalError("TLS is enabled, but no X509 certificate path was specified in current configuration", nil)
-		}
-		if conf.Minosse.TLS.X509RootCAPath == "" {
-			logChannel.fatalError("TLS is enabled, but no X509 Root CA path was specified in current configuration", nil)
+		// Certificates obtained through ACME or generated at startup make the [minosse.tls] one optional
+		optionalCertificate := conf.Minosse.TLS.ACME.Enabled || conf.Minosse.TLS.AutoSelfSigned
+		if !optionalCertificate || conf.Minosse.TLS.X509CertPath != "" || conf.Minosse.TLS.X509KeyPath != "" {
+			if conf.Minosse.TLS.X509CertPath == "" {
+				logChannel.fatalError("TLS is enabled, but no X509 certificate path was specified in current configuration", nil)
+			}
+			if conf.Minosse.TLS.X509KeyPath == "" {
+				logChannel.fatalError("TLS is enabled, but no X509 key path was specified in current configuration", nil)
+			}
 		}
-		if conf.Minosse.TLS.X509KeyPath == "" {
-			logChannel.fatalError("TLS is enabled, but no X509 key path was specified in current configuration", nil)
+		if conf.Minosse.TLS.ACME.Enabled {
+			if conf.Minosse.TLS.ACME.DirectoryURL == "" {
+				logChannel.channel <- Log{level: INFO, message: "Using Let's Encrypt production ACME directory"}
+				conf.Minosse.TLS.ACME.DirectoryURL = autocert.DefaultACMEDirectory
+			}
+			if conf.Minosse.TLS.ACME.CacheDir == "" {
+				conf.Minosse.TLS.ACME.CacheDir = ACMECacheDir
+			}
 		}
 		if conf.Minosse.TLS.Port == 0 {
 			conf.Minosse.TLS.Port = 8000
 		}
-	}
-	// Web root
-	if conf.Minosse.WebRoot == "" {
-		logChannel.fatalError("No webroot was specified in current configuration", nil)
-	} else {
-		logChannel.channel <- Log{level: INFO, message: "Serving static files", data: []zap.Field{zap.String("Directory", conf.Minosse.WebRoot)}}
+		if conf.Minosse.TLS.ClientAuth == "" {
+			conf.Minosse.TLS.ClientAuth = CLIENT_AUTH_NONE
+		} else if _, ok := clientAuthTypes[conf.Minosse.TLS.ClientAuth]; !ok {
+			logChannel.fatalError("The specified client authentication mode is not valid. Possible values are: none | request | require | verify", nil)
+		}
+		if conf.Minosse.TLS.ClientAuth == CLIENT_AUTH_VERIFY && conf.Minosse.TLS.X509RootCAPath == "" {
+			logChannel.fatalError("Client certificates have to be verified, but no X509 Root CA path was specified in current configuration", nil)
+		}
+		if conf.Minosse.TLS.MinVersion == "" {
+			conf.Minosse.TLS.MinVersion = "1.2"
+		}
+		if message := validateTLSParameters(&conf.Minosse.TLS); message != "" {
+			logChannel.fatalError(message, nil)
+		}
+		if conf.Minosse.TLS.SessionTicketKeyFile != "" && conf.Minosse.TLS.SessionTicketsDisabled {
+			logChannel.fatalError("A session ticket key file was specified, but session tickets are disabled", nil)
+		}
+		if conf.Minosse.TLS.RedirectStatus == 0 {
+			conf.Minosse.TLS.RedirectStatus = 301
+		} else if conf.Minosse.TLS.RedirectStatus != 301 && conf.Minosse.TLS.RedirectStatus != 308 {
+			logChannel.fatalError("The specified HTTPS redirect status is not valid. Possible values are: 301 | 308", nil)
+		}
+		if conf.Minosse.TLS.HSTS.MaxAge < 0 {
+			logChannel.fatalError("The specified HSTS max-age is invalid because it is negative.", nil)
+		}
+		if conf.Minosse.TLS.HSTS.Preload && (conf.Minosse.TLS.HSTS.MaxAge < HSTS_PRELOAD_MIN_MAX_AGE || !conf.Minosse.TLS.HSTS.IncludeSubDomains) {
+			logChannel.channel <- Log{level: WARNING, message: "HSTS preload lists require a max-age of at least one year and includeSubDomains"}
+		}
+		strictTransportSecurity = strictTransportSecurityValue(conf.Minosse.TLS.HSTS)
 	}
 	// Connection timeout
 	if conf.Minosse.Connections.ReadTimeout == 0 {
@@ -168,25 +263,113 @@ func applyDefaultConfigValues(conf *Config) {
 		logChannel.channel <- Log{level: INFO, message: "Using default connection write timeout of 30 seconds"}
 		conf.Minosse.Connections.WriteTimeout = SocketWriteTimeout
 	}
-	// Gzip
-	if conf.Minosse.Gzip.Enabled {
-		if conf.Minosse.Gzip.Exclude == "" {
-			logChannel.channel <- Log{level: INFO, message: "Using default gzip configuration will NOT compress images and pdf files"}
-			conf.Minosse.Gzip.Exclude = "(jpeg|jpg|png|pdf)$"
+	// Keep-alive
+	if conf.Minosse.Connections.IdleTimeout == 0 {
+		logChannel.channel <- Log{level: INFO, message: "Using default keep-alive idle timeout of 5 seconds"}
+		conf.Minosse.Connections.IdleTimeout = SocketIdleTimeout
+	}
+	if conf.Minosse.Connections.MaxRequests == 0 {
+		logChannel.channel <- Log{level: INFO, message: "Using default maximum of 100 requests per keep-alive connection"}
+		conf.Minosse.Connections.MaxRequests = MaxRequestsPerConnection
+	}
+	// Sendfile
+	if conf.Minosse.Sendfile.Enabled {
+		if conf.Minosse.Sendfile.ChunkSize == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default sendfile chunk size of 2MB"}
+			conf.Minosse.Sendfile.ChunkSize = SendfileChunkSize
+		} else if conf.Minosse.Sendfile.ChunkSize < 0 {
+			logChannel.fatalError("The specified sendfile chunk size is invalid because it is negative.", nil)
+		}
+	}
+	// File cache
+	if conf.Minosse.Cache.Enabled {
+		if conf.Minosse.Cache.MaxFileSize == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default file cache size limit. Files over 1MB will not be cached."}
+			conf.Minosse.Cache.MaxFileSize = FileCacheMaxFileSize
+		}
+		if conf.Minosse.Cache.MaxBytes == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default file cache memory budget of 64MB"}
+			conf.Minosse.Cache.MaxBytes = FileCacheMaxBytes
+		}
+		if conf.Minosse.Cache.MaxEntries == 0 {
+			conf.Minosse.Cache.MaxEntries = FileCacheMaxEntries
+		}
+		if conf.Minosse.Cache.PollInterval == 0 {
+			conf.Minosse.Cache.PollInterval = FileCachePollInterval
+		}
+		if conf.Minosse.Cache.MaxFileSize < 0 || conf.Minosse.Cache.MaxBytes < 0 || conf.Minosse.Cache.MaxEntries < 0 || conf.Minosse.Cache.PollInterval < 0 {
+			logChannel.fatalError("The specified file cache configuration is invalid because it contains negative values.", nil)
+		}
+	}
+	// Compression
+	if conf.Minosse.Gzip.Enabled && !conf.Minosse.Compression.Enabled {
+		logChannel.channel <- Log{level: WARNING, message: "The [minosse.gzip] configuration section is deprecated, please move its settings to [minosse.compression]"}
+		conf.Minosse.Compression = Compression{
+			Enabled:         true,
+			Encodings:       []string{GZIP},
+			GzipLevel:       conf.Minosse.Gzip.Level,
+			Threshold:       conf.Minosse.Gzip.Threshold,
+			Exclude:         conf.Minosse.Gzip.Exclude,
+			Stream:          conf.Minosse.Gzip.Stream,
+			MaxBufferedSize: conf.Minosse.Gzip.MaxBufferedSize,
 		}
-		if conf.Minosse.Gzip.Level == 0 {
-			logChannel.channel <- Log{level: INFO, message: "Using default gzip compression level. You can specify the compression level in the configuration file; possible values range from 1 (Best speed) to 9 (Best compression)"}
-			conf.Minosse.Gzip.Level = gzip.DefaultCompression
-		} else if conf.Minosse.Gzip.Level > 9 {
-			logChannel.fatalError("The specified gzip level is not valid. Possible values range from 1 (Best speed) to 9 (Best compression)", nil)
+	}
+	if conf.Minosse.Compression.Cache.Enabled {
+		if conf.Minosse.Compression.Cache.MaxBytes == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default compressed cache size of 64MB"}
+			conf.Minosse.Compression.Cache.MaxBytes = CompressionCacheMaxBytes
+		} else if conf.Minosse.Compression.Cache.MaxBytes < 0 {
+			logChannel.fatalError("The specified compressed cache size is invalid because it is negative.", nil)
 		}
-		if conf.Minosse.Gzip.Threshold == 0 {
-			logChannel.channel <- Log{level: INFO, message: "Using default gzip file-size threshold. File under 1.5KB will not be compressed."}
-			conf.Minosse.Gzip.Threshold = 1500
-		} else if conf.Minosse.Gzip.Threshold < 0 {
-			logChannel.fatalError("The specified gzip file size threshold is invalid because it is negative.", nil)
+		if conf.Minosse.Compression.Cache.MaxEntries == 0 {
+			logChannel.channel <- Log{level: INFO, message: "Using default compressed cache limit of 1024 entries"}
+			conf.Minosse.Compression.Cache.MaxEntries = CompressionCacheMaxEntries
+		} else if conf.Minosse.Compression.Cache.MaxEntries < 0 {
+			logChannel.fatalError("The specified compressed cache entries limit is invalid because it is negative.", nil)
+		}
+	}
+	// Sites
+	conf.Sites = make([]Site, len(conf.siteTables))
+	defaultSite := -1
+	for i, table := range conf.siteTables {
+		site, err := inheritSite(conf.Minosse.Site, table)
+		if err != nil {
+			logChannel.fatalError("Error in minosse configuration file, invalid [[site]] table", err)
+		}
+		if len(site.Hosts) == 0 && !site.Default {
+			logChannel.fatalError("Every [[site]] needs at least one host, unless it is the default one", nil)
+		}
+		conf.Sites[i] = site
+		if site.Default {
+			defaultSite = i
+		}
+	}
+	// Without a webroot nor hosts of its own, [minosse] only holds the settings inherited by the [[site]] tables, as
+	// long as one of them serves unknown hosts
+	if conf.Minosse.WebRoot != "" || len(conf.Minosse.Hosts) > 0 || defaultSite < 0 {
+		defaultHost = newVirtualHost(&conf.Minosse.Site)
+		registerVirtualHost(defaultHost)
+	}
+	for i := range conf.Sites {
+		vh := newVirtualHost(&conf.Sites[i])
+		registerVirtualHost(vh)
+		if i == defaultSite {
+			defaultHost = vh
+		}
+	}
+	for _, vh := range virtualHosts {
+		if len(vh.Access.ClientCertPrefixes) == 0 {
+			continue
+		}
+		if !conf.Minosse.TLS.Enabled || conf.Minosse.TLS.X509RootCAPath == "" {
+			logChannel.channel <- Log{level: WARNING, message: "Client certificates cannot be verified without TLS and an X509 Root CA path, requests for these prefixes will always be denied", data: []zap.Field{zap.Strings("prefixes", vh.Access.ClientCertPrefixes)}}
+			continue
+		}
+		// Clients never send a certificate unless asked for one during the handshake
+		if conf.Minosse.TLS.ClientAuth == CLIENT_AUTH_NONE {
+			logChannel.channel <- Log{level: INFO, message: "Client certificates are needed for some prefixes, asking clients for one during the handshake", data: []zap.Field{zap.Strings("prefixes", vh.Access.ClientCertPrefixes)}}
+			conf.Minosse.TLS.ClientAuth = CLIENT_AUTH_REQUEST
 		}
-		excludePattern = regexp.MustCompile(conf.Minosse.Gzip.Exclude)
 	}
 }
 
@@ -238,116 +421,388 @@ func listen(l net.Listener, newConnections chan net.Conn) {
 	}
 }
 
-func worker(newConnections chan net.Conn, rl ratelimit.Limiter) {
-	var req http.Request
-	bufferedReader := bufio.NewReader(nil)
+// worker State reused across the requests answered by a worker: the response writer and the encoders of every site
+type worker struct {
+	bufferedWriter *bufio.Writer
+	// Compression settings may differ between sites, hence so do encoders
+	encoders map[*virtualHost]map[string]encoder
+}
+
+// newWorkers Creates a pool of n workers. Connections take one only while answering requests, so that the pool bounds
+// the requests in flight rather than the open connections
+func newWorkers(n int) chan *worker {
+	workers := make(chan *worker, n)
+	for i := 0; i < n; i++ {
+		w := &worker{bufferedWriter: bufio.NewWriter(nil), encoders: make(map[*virtualHost]map[string]encoder)}
+		for _, vh := range virtualHosts {
+			w.encoders[vh] = newEncoders(vh.Compression)
+		}
+		workers <- w
+	}
+	return workers
+}
 
+// serve Handles every new connection in its own goroutine, until newConnections is closed and every connection is
+// done. Waiting for the requests of a client, TLS handshake included, holds no worker: idle keep-alive connections
+// never keep other clients waiting
+func serve(newConnections chan net.Conn, workers chan *worker, rl ratelimit.Limiter) {
+	var connections sync.WaitGroup
 	for c := range newConnections {
+		if c == nil {
+			continue
+		}
 		rl.Take()
-		handleConnection(c, &req, bufferedReader)
+		connections.Add(1)
+		go func(c net.Conn) {
+			defer connections.Done()
+			handleConnection(c, workers)
+		}(c)
 	}
+	connections.Wait()
 }
 
-func gzipFilter(f os.FileInfo) bool {
-	return f.Size() > config.Minosse.Gzip.Threshold && !excludePattern.MatchString(f.Name())
+// handleConnection Answers the requests of a connection, taking a worker from workers for each of them, or for each
+// batch of pipelined ones, and giving it back before waiting for the next
+func handleConnection(conn net.Conn, workers chan *worker) {
+	defer conn.Close()
+	bufferedReader := bufio.NewReader(conn)
+	var w *worker
+	defer func() {
+		if w != nil {
+			workers <- w
+		}
+	}()
+
+	for served := 1; ; served++ {
+		// The first request gets the full read timeout, following ones only wait for the keep-alive idle timeout
+		readTimeout := config.Minosse.Connections.ReadTimeout
+		if served > 1 {
+			readTimeout = config.Minosse.Connections.IdleTimeout
+		}
+		if err := conn.SetReadDeadline(time.Now().Add(time.Second * time.Duration(readTimeout))); err != nil {
+			logChannel.error("Error setting read deadline", err)
+			return
+		}
+
+		start := time.Now()
+		req, err := http.ReadRequest(bufferedReader)
+		if err != nil {
+			if served == 1 || !isIdleConnectionClosed(err) {
+				logChannel.error(GENERIC_ERROR_MESSAGE_LOG, err)
+			}
+			return
+		}
+		req.RemoteAddr = conn.RemoteAddr().String()
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			req.TLS = &state
+		}
+
+		if err := conn.SetWriteDeadline(time.Now().Add(time.Second * time.Duration(config.Minosse.Connections.WriteTimeout))); err != nil {
+			logChannel.error("Error setting write deadline", err)
+			return
+		}
+
+		if w == nil {
+			w = <-workers
+			w.bufferedWriter.Reset(conn)
+		}
+		keepAlive := shouldKeepAlive(req, served)
+		vh := selectVirtualHost(req.Host)
+		response, ok := handleRequest(w.bufferedWriter, conn, req, vh, w.encoders[vh], keepAlive)
+		logChannel.logWholeRequest(req, &response, &start)
+		keepAlive = ok && keepAlive && discardRequestBody(req)
+
+		// Pipelined requests already sitting in the read buffer are answered before flushing, so that their responses
+		// leave in order and possibly within the same packets
+		if !keepAlive || !hasBufferedRequest(bufferedReader) {
+			if err := w.bufferedWriter.Flush(); err != nil {
+				logChannel.error("Error writing response", err)
+				return
+			}
+			workers <- w
+			w = nil
+		}
+		if !keepAlive {
+			return
+		}
+	}
 }
 
-func handleConnection(conn net.Conn, req *http.Request, bufferedReader *bufio.Reader) {
-	defer conn.Close()
-	start := time.Now()
+// shouldKeepAlive Reports whether the connection can be reused after answering req. http.ReadRequest already takes care
+// of HTTP/1.0 (keep-alive must be explicitly requested) and HTTP/1.1 (persistent unless "Connection: close") semantics
+func shouldKeepAlive(req *http.Request, served int) bool {
+	if req.Close {
+		return false
+	}
+	maxRequests := config.Minosse.Connections.MaxRequests
+	return maxRequests <= 0 || served < maxRequests
+}
 
-	if err := conn.SetReadDeadline(time.Now().Add(time.Second * time.Duration(config.Minosse.Connections.ReadTimeout))); err != nil {
-		logChannel.error("Error setting read deadline", err)
-		return
+// isIdleConnectionClosed Reports whether err is the expected outcome of a client closing (or abandoning) an idle keep-alive connection
+func isIdleConnectionClosed(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
 	}
-	if err := conn.SetWriteDeadline(time.Now().Add(time.Second * time.Duration(config.Minosse.Connections.WriteTimeout))); err != nil {
-		logChannel.error("Error setting write deadline", err)
-		return
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// discardRequestBody Consumes what is left of the request body, so that the next request on the same connection can be read.
+// Returns false when the body is too big (or broken) and the connection should be closed instead
+func discardRequestBody(req *http.Request) bool {
+	if req.ContentLength == 0 {
+		return true
+	}
+	n, err := io.CopyN(ioutil.Discard, req.Body, MaxDiscardedBodySize+1)
+	if err == io.EOF {
+		return true
+	}
+	return err == nil && n <= MaxDiscardedBodySize
+}
+
+// hasBufferedRequest Reports whether the reader already holds the complete header block of a pipelined request,
+// which can be parsed without waiting for the network
+func hasBufferedRequest(bufferedReader *bufio.Reader) bool {
+	buffered, err := bufferedReader.Peek(bufferedReader.Buffered())
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(buffered, []byte("\r\n\r\n")) || bytes.Contains(buffered, []byte("\n\n"))
+}
+
+func connectionHeader(keepAlive bool) string {
+	if keepAlive {
+		return HEADER_CONNECTION_KEEP_ALIVE
+	}
+	return HEADER_CONNECTION_CLOSE
+}
+
+// writeResponse Writes a complete response, using the configured error page for error status codes. The body is
+// omitted when answering a HEAD request
+func writeResponse(w io.Writer, req *http.Request, response *Response, keepAlive bool) bool {
+	if response.statusCode >= 400 {
+		applyErrorPage(response, req)
+	}
+	response.Header(HEADER_CONNECTION, connectionHeader(keepAlive))
+	if req.TLS != nil && strictTransportSecurity != "" {
+		response.Header(HEADER_STRICT_TRANSPORT_SECURITY, strictTransportSecurity)
+	}
+	res := response.ToByte()
+	if req.Method == HTTP_HEAD_METHOD {
+		res = response.ResponseToByteNoBody()
 	}
+	if _, err := w.Write(res); err != nil {
+		logChannel.error("Error writing response", err)
+		return false
+	}
+	return true
+}
 
-	var gzb bytes.Buffer
+// handleRequest Serves a single request for the site vh, writing the response on w, which is flushed by the caller. conn
+// is only used directly when sending files with sendfile(2). Returns the response that was sent and whether the
+// connection is still usable
+func handleRequest(w *bufio.Writer, conn net.Conn, req *http.Request, vh *virtualHost, encoders map[string]encoder, keepAlive bool) (Response, bool) {
+	var compressedBody []byte
 	var response Response
-	bufferedReader.Reset(conn)
-	req, err := http.ReadRequest(bufferedReader)
-	defer logChannel.logWholeRequest(req, &response, &start)
 
-	if err != nil {
-		logChannel.error("Error reading request", err)
-		return
+	if acmeChallenge(req) {
+		response = serveACMEChallenge(req)
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+	if redirectToHTTPS(req) {
+		response = ResponseHTTPSRedirect(req)
+		return response, writeResponse(w, req, &response, keepAlive)
 	}
 
-	if req.Method != HTTP_GET_METHOD {
+	switch req.Method {
+	case HTTP_GET_METHOD, HTTP_HEAD_METHOD:
+	case HTTP_OPTIONS_METHOD:
+		response = ResponseOptions()
+		return response, writeResponse(w, req, &response, keepAlive)
+	default:
 		response = ResponseMethodNotAllowed()
-		_, err = conn.Write(response.ToByte())
-		if err != nil {
-			logChannel.error("Error writing response", err)
-		}
-		return
+		return response, writeResponse(w, req, &response, keepAlive)
 	}
 
-	gzipEnabled := false
-	if encoding := req.Header.Get("Accept-Encoding"); encoding != "" {
-		gzipEnabled = config.Minosse.Gzip.Enabled && strings.Contains(encoding, GZIP)
+	if denied, rule := vh.accessControl.denied(req.URL.Path); denied {
+		logChannel.channel <- Log{level: WARNING, message: "Denied access to request path", data: []zap.Field{zap.String("rule", rule), zap.String("request_uri", req.RequestURI), zap.String("request_remote_address", req.RemoteAddr)}}
+		response = ResponseNotFound()
+		return response, writeResponse(w, req, &response, keepAlive)
 	}
 
-	pathFile := config.Minosse.WebRoot + filepath.Clean(req.URL.String())
-	f, err := os.Open(pathFile)
+	urlPath, pathFile, err := vh.resolveTryFiles(req.URL)
+	// Both the requested path and the try_files candidate it resolved to are checked, so that neither a fallback nor a
+	// missing file reveal anything about protected paths
+	if vh.clientCertRequired(req.URL.Path, urlPath) && !verifiedClientCertificate(req) {
+		logChannel.channel <- Log{level: WARNING, message: "Missing or invalid client certificate", data: []zap.Field{zap.String("request_uri", req.RequestURI), zap.String("request_remote_address", req.RemoteAddr)}}
+		response = ResponseForbidden()
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+	if err == errInvalidPath {
+		logChannel.channel <- Log{level: WARNING, message: "Rejected invalid request path", data: []zap.Field{zap.String("request_uri", req.RequestURI), zap.String("request_remote_address", req.RemoteAddr)}}
+		response = ResponseBadRequest()
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+	if err == errOutsideWebRoot || err == errSymlinkNotAllowed {
+		logChannel.channel <- Log{level: WARNING, message: "Rejected request path", data: []zap.Field{zap.Error(err), zap.String("request_uri", req.RequestURI), zap.String("request_remote_address", req.RemoteAddr)}}
+	}
+	var f staticFile
+	if err == nil {
+		f, err = openFile(pathFile)
+	}
 	if err != nil {
 		logChannel.error("File not found", err)
 		response = ResponseNotFound()
-		_, err = conn.Write(response.ToByte())
-		if err != nil {
-			logChannel.error("Error writing response", err)
-		}
-		return
+		return response, writeResponse(w, req, &response, keepAlive)
 	}
+	defer f.Close()
+
 	stat, err := f.Stat()
 	if err != nil {
 		logChannel.error("Error during file stat", err)
 		response = ResponseInternalServerError()
-		_, err = conn.Write(response.ToByte())
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+
+	if stat.IsDir() {
+		// Relative links inside the index only work with the canonical, slash terminated, directory URL
+		if !strings.HasSuffix(urlPath, "/") {
+			response = ResponseMovedPermanently(directoryLocation(&url.URL{Path: urlPath, RawQuery: req.URL.RawQuery}))
+			return response, writeResponse(w, req, &response, keepAlive)
+		}
+		indexPath, index, indexStat, err := vh.openIndex(urlPath)
 		if err != nil {
-			logChannel.error("Error writing response", err)
+			if vh.autoindexEnabled(urlPath) {
+				return vh.serveAutoindex(w, req, pathFile, keepAlive)
+			}
+			response = ResponseNoIndex(vh.NoIndexStatus)
+			return response, writeResponse(w, req, &response, keepAlive)
 		}
-		return
-	} else {
-		var encoding string
-		var contentLength string
-		gzipEnabled = gzipEnabled && gzipFilter(stat)
-		if gzipEnabled {
-			encoding = "gzip"
-			gzipWriter, _ := gzip.NewWriterLevel(&gzb, config.Minosse.Gzip.Level)
-			if _, err := io.Copy(gzipWriter, f); err != nil {
-				logChannel.error("Error during gzip compression", err)
-				return
+		defer index.Close()
+		pathFile, f, stat = indexPath, index, indexStat
+	}
+
+	var contentLength string
+	compressible := vh.Compression.Enabled && vh.compressionFilter(stat)
+	acceptEncoding := req.Header.Get(HEADER_ACCEPT_ENCODING)
+
+	// body is what gets sent as is: either the requested file or its precompressed sidecar
+	var body staticFile = f
+	bodyPath, bodyStat := pathFile, stat
+	encoding := IDENTITY
+	if compressible && vh.Compression.Precompressed {
+		if sidecar, sidecarStat, sidecarEncoding := openSidecar(pathFile, stat, acceptEncoding, vh.Compression.Encodings); sidecar != nil {
+			defer sidecar.Close()
+			body, bodyPath, bodyStat, encoding = sidecar, sidecar.Name(), sidecarStat, sidecarEncoding
+		}
+	}
+
+	var enc encoder
+	compressed := false
+	if compressible && encoding == IDENTITY && vh.onTheFlyCompression(stat) {
+		encoding = negotiateEncoding(acceptEncoding, vh.Compression.Encodings)
+		enc, compressed = encoders[encoding]
+		if !compressed {
+			encoding = IDENTITY
+		}
+	}
+	if encoding == IDENTITY && !acceptsIdentity(acceptEncoding) {
+		response = ResponseNotAcceptable(map[string]string{HEADER_VARY: HEADER_ACCEPT_ENCODING})
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+
+	etag, err := entityTag(body, bodyPath, bodyStat, encoding, vh.ETag)
+	if err != nil {
+		logChannel.error("Error while generating ETag", err)
+		response = ResponseInternalServerError()
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+	headers := map[string]string{HEADER_CACHE_CONTROL: vh.CacheControl, HEADER_LAST_MODIFIED: stat.ModTime().UTC().Format(http.TimeFormat), HEADER_DATE: time.Now().UTC().Format(http.TimeFormat), HEADER_SERVER: HEADER_SERVER_VALUE, HEADER_CONTENT_ENCODING: encoding}
+	if etag != "" {
+		headers[HEADER_ETAG] = etag
+	}
+	// The representation depends on Accept-Encoding even when identity was chosen, caches have to know about it
+	if compressible {
+		headers[HEADER_VARY] = HEADER_ACCEPT_ENCODING
+	}
+
+	switch checkPreconditions(req, etag, stat.ModTime()) {
+	case http.StatusNotModified:
+		response = ResponseNotModified(headers)
+		return response, writeResponse(w, req, &response, keepAlive)
+	case http.StatusPreconditionFailed:
+		response = ResponsePreconditionFailed()
+		return response, writeResponse(w, req, &response, keepAlive)
+	}
+
+	headers[HEADER_CONTENT_TYPE] = mime.TypeByExtension(path.Ext(pathFile))
+	// Byte ranges are only served for the identity encoding, straight from the file
+	if encoding == IDENTITY {
+		headers[HEADER_ACCEPT_RANGES] = RANGE_UNIT_BYTES
+		if rangeHeader := req.Header.Get(HEADER_RANGE); rangeHeader != "" && req.Method == HTTP_GET_METHOD && checkIfRange(req, etag, stat.ModTime()) {
+			ranges, err := parseRange(rangeHeader, stat.Size())
+			if err == errUnsatisfiableRange {
+				response = ResponseRangeNotSatisfiable(stat.Size())
+				return response, writeResponse(w, req, &response, keepAlive)
 			}
-			if err := gzipWriter.Close(); err != nil {
-				logChannel.error("Error while closing gzip compression", err)
-				return
+			// Ranges which add up to more than the whole file are not worth it: the full content is sent instead
+			if err == nil && len(ranges) > 0 && rangesLength(ranges) <= stat.Size() {
+				return serveRanges(w, conn, req, f, stat, ranges, headers, keepAlive)
+			}
+		}
+	}
+
+	if compressed && vh.streamCompression(stat) {
+		return serveCompressedStream(w, req, f, headers, enc, keepAlive)
+	}
+
+	if compressed {
+		var ok bool
+		if compressedBody, ok = getCompressed(pathFile, stat, encoding); !ok {
+			var buffer bytes.Buffer
+			enc.Reset(&buffer)
+			if _, err := io.Copy(enc, f); err != nil {
+				logChannel.error("Error during compression", err)
+				response = ResponseInternalServerError()
+				return response, writeResponse(w, req, &response, keepAlive)
+			}
+			if err := enc.Close(); err != nil {
+				logChannel.error("Error while closing compression", err)
+				response = ResponseInternalServerError()
+				return response, writeResponse(w, req, &response, keepAlive)
 			}
-			contentLength = strconv.FormatInt(int64(gzb.Len()), 10)
-		} else {
-			encoding = "identity"
-			contentLength = strconv.FormatInt(stat.Size(), 10)
+			compressedBody = buffer.Bytes()
+			putCompressed(pathFile, stat, encoding, compressedBody)
 		}
-		response = ResponseOkNoBody(map[string]string{HEADER_CONTENT_TYPE: mime.TypeByExtension(path.Ext(pathFile)), HEADER_CONTENT_LENGTH: contentLength, HEADER_CONTENT_ENCODING: encoding, HEADER_CACHE_CONTROL: HEADER_CACHE_CONTROL_DEFAULT_VALUE, HEADER_CONNECTION: HEADER_CONNECTION_CLOSE, HEADER_LAST_MODIFIED: stat.ModTime().Format(http.TimeFormat), HEADER_DATE: time.Now().Format(http.TimeFormat), HEADER_SERVER: HEADER_SERVER_VALUE})
+		contentLength = strconv.Itoa(len(compressedBody))
+	} else {
+		contentLength = strconv.FormatInt(bodyStat.Size(), 10)
 	}
+	headers[HEADER_CONTENT_LENGTH] = contentLength
+	setConnectionHeaders(headers, req, keepAlive)
+	response = ResponseOkNoBody(headers)
 
-	_, err = conn.Write(response.ResponseToByteNoBody())
+	_, err = w.Write(response.ResponseToByteNoBody())
 	if err != nil && err != io.EOF {
 		logChannel.error("Error writing response", err)
-		return
+		return response, false
+	}
+	// HEAD gets exactly the same headers as GET, Content-Length included, but no body
+	if req.Method == HTTP_HEAD_METHOD {
+		return response, true
 	}
 
-	if gzipEnabled {
-		if _, err := io.Copy(conn, &gzb); err != nil {
+	if compressed {
+		if _, err := w.Write(compressedBody); err != nil {
 			logChannel.error("Error writing response", err)
-			return
+			return response, false
 		}
 	} else {
-		if _, err := io.Copy(conn, f); err != nil {
+		if _, err := sendFile(w, conn, body, 0, bodyStat.Size()); err != nil {
 			logChannel.error("Error writing response", err)
-			return
+			return response, false
 		}
 	}
+	return response, true
 }
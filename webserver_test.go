@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepAlive(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "first", "b.txt": "second"})
+	addr := startTestServer(t)
+
+	conn := dialTestServer(t, addr)
+	reader := bufio.NewReader(conn)
+	for _, file := range []string{"a.txt", "b.txt", "a.txt"} {
+		if _, err := io.WriteString(conn, rawRequest("GET", "/"+file)); err != nil {
+			t.Fatal(err)
+		}
+		res := readTestResponse(t, reader, "GET")
+		if res.StatusCode != 200 || res.Header.Get("Connection") != "keep-alive" {
+			t.Errorf("GET /%s: got status %d and Connection %q, want 200 and keep-alive", file, res.StatusCode, res.Header.Get("Connection"))
+		}
+	}
+}
+
+func TestConnectionClose(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Connections.MaxRequests = 2
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "a"})
+	addr := startTestServer(t)
+
+	tests := []struct {
+		name     string
+		requests []string
+	}{
+		{"Connection: close", []string{rawRequest("GET", "/a.txt", "Connection: close")}},
+		{"HTTP/1.0", []string{"GET /a.txt HTTP/1.0\r\n\r\n"}},
+		{"maximum number of requests", []string{rawRequest("GET", "/a.txt"), rawRequest("GET", "/a.txt")}},
+		{"error response", []string{rawRequest("GET", "/missing.txt", "Connection: close")}},
+	}
+	for _, test := range tests {
+		conn := dialTestServer(t, addr)
+		reader := bufio.NewReader(conn)
+		for i, request := range test.requests {
+			if _, err := io.WriteString(conn, request); err != nil {
+				t.Fatal(err)
+			}
+			res := readTestResponse(t, reader, "GET")
+			// http.ReadResponse turns "Connection: close" into res.Close
+			if last := i == len(test.requests)-1; res.Close != last || !last && res.Header.Get("Connection") != "keep-alive" {
+				t.Errorf("%s: response %d has Connection %q (close %t)", test.name, i, res.Header.Get("Connection"), res.Close)
+			}
+		}
+		assertClosed(t, reader)
+	}
+}
+
+func TestHTTP10KeepAlive(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "a"})
+	addr := startTestServer(t)
+
+	responses := roundTrip(t, addr, "GET /a.txt HTTP/1.0\r\nConnection: keep-alive\r\n\r\n", "GET /a.txt HTTP/1.0\r\n\r\n")
+	if connection := responses[0].Header.Get("Connection"); connection != "keep-alive" {
+		t.Errorf("got Connection %q, want keep-alive", connection)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Connections.IdleTimeout = 1
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "a"})
+	addr := startTestServer(t)
+
+	conn := dialTestServer(t, addr)
+	reader := bufio.NewReader(conn)
+	if _, err := io.WriteString(conn, rawRequest("GET", "/a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	readTestResponse(t, reader, "GET")
+	start := time.Now()
+	assertClosed(t, reader)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("idle connection closed after %s, want about 1s", elapsed)
+	}
+}
+
+func TestIdleConnectionsHoldNoWorker(t *testing.T) {
+	for _, workers := range []int{1, 3} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			conf := newTestConfig(t)
+			conf.Minosse.Connections.IdleTimeout = 30
+			writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "a"})
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			addr := serveTestWorkers(t, listener, workers)
+
+			// As many idle keep-alive connections as workers, plus one still sending its request
+			var idle []net.Conn
+			var readers []*bufio.Reader
+			for i := 0; i < workers; i++ {
+				conn := dialTestServer(t, addr)
+				reader := bufio.NewReader(conn)
+				if _, err := io.WriteString(conn, rawRequest("GET", "/a.txt")); err != nil {
+					t.Fatal(err)
+				}
+				readTestResponse(t, reader, "GET")
+				idle, readers = append(idle, conn), append(readers, reader)
+			}
+			slow := dialTestServer(t, addr)
+			if _, err := io.WriteString(slow, "GET /a.txt HTTP/1.1\r\nHost: local"); err != nil {
+				t.Fatal(err)
+			}
+
+			// Another client is answered well before the idle timeout
+			conn := dialTestServer(t, addr)
+			conn.SetDeadline(time.Now().Add(2 * time.Second))
+			if _, err := io.WriteString(conn, rawRequest("GET", "/a.txt", "Connection: close")); err != nil {
+				t.Fatal(err)
+			}
+			if res := readTestResponse(t, bufio.NewReader(conn), "GET"); res.body != "a" {
+				t.Errorf("got %q, want a", res.body)
+			}
+
+			// Idle connections are still usable
+			for i, conn := range idle {
+				if _, err := io.WriteString(conn, rawRequest("GET", "/a.txt")); err != nil {
+					t.Fatal(err)
+				}
+				if res := readTestResponse(t, readers[i], "GET"); res.body != "a" {
+					t.Errorf("idle connection %d: got %q, want a", i, res.body)
+				}
+			}
+			if _, err := io.WriteString(slow, "host\r\n\r\n"); err != nil {
+				t.Fatal(err)
+			}
+			if res := readTestResponse(t, bufio.NewReader(slow), "GET"); res.body != "a" {
+				t.Errorf("slow client: got %q, want a", res.body)
+			}
+		})
+	}
+}
+
+func TestRequestBodyDiscarded(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "a"})
+	addr := startTestServer(t)
+
+	body := strings.Repeat("x", 1000)
+	responses := roundTrip(t, addr, rawRequest("POST", "/a.txt", "Content-Length: 1000")+body, rawRequest("GET", "/a.txt", "Connection: close"))
+	if responses[0].StatusCode != 405 || responses[1].StatusCode != 200 || responses[1].body != "a" {
+		t.Errorf("got statuses %d and %d, want 405 and 200", responses[0].StatusCode, responses[1].StatusCode)
+	}
+}
+
+func TestPipelinedRequests(t *testing.T) {
+	conf := newTestConfig(t)
+	conf.Minosse.Compression = Compression{Enabled: true, Encodings: []string{GZIP}}
+	large := strings.Repeat("compressible content ", 500)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "first", "large.txt": large, "b.txt": "last"})
+	addr := startTestServer(t)
+
+	// Every request is sent at once, before reading any response
+	responses := roundTrip(t, addr,
+		rawRequest("GET", "/a.txt"),
+		rawRequest("GET", "/missing.txt"),
+		rawRequest("GET", "/large.txt", "Accept-Encoding: gzip"),
+		rawRequest("GET", "/b.txt", "Connection: close"),
+	)
+	expected := []struct {
+		status   int
+		encoding string
+		body     string
+	}{
+		{200, "identity", "first"},
+		{404, "", ""},
+		{200, "gzip", large},
+		{200, "identity", "last"},
+	}
+	for i, want := range expected {
+		res := responses[i]
+		body := res.body
+		if want.encoding == "gzip" {
+			body = gunzip(t, res.body)
+		}
+		if res.StatusCode != want.status {
+			t.Errorf("response %d: got status %d, want %d", i, res.StatusCode, want.status)
+		}
+		if encoding := res.Header.Get("Content-Encoding"); encoding != want.encoding {
+			t.Errorf("response %d: got Content-Encoding %q, want %q", i, encoding, want.encoding)
+		}
+		if want.body != "" && body != want.body {
+			t.Errorf("response %d: got body %q, want %q", i, body, want.body)
+		}
+	}
+}
+
+func TestPipelinedRequestSplitAcrossWrites(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "first", "b.txt": "second"})
+	addr := startTestServer(t)
+
+	// The second request is cut in the middle: the first response must not wait for the rest of it
+	conn := dialTestServer(t, addr)
+	reader := bufio.NewReader(conn)
+	if _, err := io.WriteString(conn, rawRequest("GET", "/a.txt")+"GET /b.txt HTTP/1.1\r\nHo"); err != nil {
+		t.Fatal(err)
+	}
+	if res := readTestResponse(t, reader, "GET"); res.body != "first" {
+		t.Errorf("first response: got body %q", res.body)
+	}
+	if _, err := io.WriteString(conn, "st: localhost\r\nConnection: close\r\n\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if res := readTestResponse(t, reader, "GET"); res.body != "second" {
+		t.Errorf("second response: got body %q", res.body)
+	}
+	assertClosed(t, reader)
+}
+
+func TestMethods(t *testing.T) {
+	conf := newTestConfig(t)
+	writeTestFiles(t, conf.Minosse.WebRoot, map[string]string{"a.txt": "content"})
+	addr := startTestServer(t)
+
+	// Pipelined after each HEAD, a GET shows whether a body was sent anyway
+	responses := roundTrip(t, addr,
+		rawRequest("HEAD", "/a.txt"),
+		rawRequest("GET", "/a.txt"),
+		rawRequest("HEAD", "/missing.txt"),
+		rawRequest("OPTIONS", "*"),
+		rawRequest("POST", "/a.txt"),
+		rawRequest("DELETE", "/a.txt"),
+		rawRequest("GET", "/a.txt", "Connection: close"),
+	)
+	head, get := responses[0], responses[1]
+	if head.StatusCode != 200 || head.body != "" {
+		t.Errorf("HEAD: got status %d and body %q, want 200 without body", head.StatusCode, head.body)
+	}
+	for _, header := range []string{"Content-Length", "Content-Type", "Last-Modified"} {
+		if head.Header.Get(header) != get.Header.Get(header) {
+			t.Errorf("HEAD and GET have different %s: %q and %q", header, head.Header.Get(header), get.Header.Get(header))
+		}
+	}
+	if get.body != "content" {
+		t.Errorf("GET after HEAD: got body %q", get.body)
+	}
+	if res := responses[2]; res.StatusCode != 404 || res.body != "" {
+		t.Errorf("HEAD on a missing file: got status %d and body %q, want 404 without body", res.StatusCode, res.body)
+	}
+	if res := responses[3]; res.StatusCode != 200 || res.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+		t.Errorf("OPTIONS: got status %d and Allow %q", res.StatusCode, res.Header.Get("Allow"))
+	}
+	for _, res := range responses[4:6] {
+		if res.StatusCode != 405 || res.Header.Get("Allow") != "GET, HEAD, OPTIONS" {
+			t.Errorf("unsupported method: got status %d and Allow %q, want 405 with the allowed methods", res.StatusCode, res.Header.Get("Allow"))
+		}
+	}
+	if res := responses[6]; res.body != "content" {
+		t.Errorf("last GET: got body %q", res.body)
+	}
+}